@@ -0,0 +1,122 @@
+package starlight
+
+import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+// BatchOptions configures Cache.RunBatch.
+type BatchOptions struct {
+	// Context cancels the batch: inputs not started yet fail with its
+	// error, and running ones are cancelled. Nil means context.Background().
+	Context context.Context
+	// MaxConcurrency bounds how many inputs run at the same time. Zero or
+	// less means runtime.GOMAXPROCS(0).
+	MaxConcurrency int
+	// Shared holds globals common to every input. They are converted once
+	// for the whole batch and, since concurrent runs see the same values,
+	// frozen; an input global with the same name wins.
+	Shared map[string]interface{}
+	// RunOptions configure the run of every input.
+	RunOptions []RunOption
+}
+
+// BatchResult is the outcome of running the script for one input.
+type BatchResult struct {
+	Globals map[string]interface{} // as returned by Cache.Run
+	Err     error
+}
+
+// RunBatch runs the script filename once per input, like Run, on a bounded
+// pool of goroutines. The script is compiled once per distinct set of
+// global names before the fan-out, and the shared globals are converted
+// once. Results are returned in input order, each with its own error; the
+// returned error is only non-nil if the batch could not start at all (e.g.
+// a shared global could not be converted). Opts may be nil.
+func (c *Cache) RunBatch(filename string, inputs []map[string]interface{}, opts *BatchOptions) ([]BatchResult, error) {
+	if opts == nil {
+		opts = &BatchOptions{}
+	}
+	ctx := opts.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	runOpts := append(append([]RunOption(nil), opts.RunOptions...), WithContext(ctx))
+	conv := c.runConfig(runOpts)
+	shared, err := conv.makeDict(opts.Shared)
+	if err != nil {
+		return nil, err
+	}
+	shared.Freeze()
+	results := make([]BatchResult, len(inputs))
+	dicts := make([]starlark.StringDict, len(inputs))
+	programs := make([]*starlark.Program, len(inputs))
+	for i, in := range inputs {
+		d, err := conv.makeDict(in)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		dicts[i] = mergeDicts(shared, d)
+		// resolve the program up front, compiling it once per name set, so
+		// workers run it without going back to the loader
+		if programs[i], err = c.program(filename, dicts[i]); err != nil {
+			results[i].Err = err
+			dicts[i] = nil
+		}
+	}
+
+	workers := opts.MaxConcurrency
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > len(inputs) {
+		workers = len(inputs)
+	}
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if err := ctx.Err(); err != nil {
+					results[i].Err = err
+					continue
+				}
+				rc := c.runConfig(runOpts)
+				ret, err := c.execProgram(programs[i], dicts[i], rc)
+				if err != nil {
+					results[i].Err = err
+					continue
+				}
+				results[i].Globals = convert.FromStringDict(rc.filter.apply(ret, dicts[i]))
+			}
+		}()
+	}
+	for i := range inputs {
+		if dicts[i] != nil {
+			next <- i
+		}
+	}
+	close(next)
+	wg.Wait()
+	return results, nil
+}
+
+// mergeDicts returns a new dict holding the entries of base overlaid with
+// those of over.
+func mergeDicts(base, over starlark.StringDict) starlark.StringDict {
+	merged := make(starlark.StringDict, len(base)+len(over))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range over {
+		merged[k] = v
+	}
+	return merged
+}
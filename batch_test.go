@@ -0,0 +1,152 @@
+package starlight
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunBatch(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"rule.star": `
+def rule():
+    if x < 0:
+        fail("negative: %d" % x)
+    return (x * factor, track())
+out = rule()
+`,
+	})
+	c := New(dir)
+	var running, peak int32
+	track := func() int {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		return 0
+	}
+
+	inputs := make([]map[string]interface{}, 200)
+	for i := range inputs {
+		inputs[i] = map[string]interface{}{"x": i}
+	}
+	inputs[7] = map[string]interface{}{"x": -1}
+	inputs[9] = map[string]interface{}{"x": uintptr(1)}
+	res, err := c.RunBatch("rule.star", inputs, &BatchOptions{
+		MaxConcurrency: 3,
+		Shared:         map[string]interface{}{"factor": 2, "track": track},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != len(inputs) {
+		t.Fatalf("got %d results, want %d", len(res), len(inputs))
+	}
+	for i, r := range res {
+		switch i {
+		case 7:
+			if r.Err == nil || !strings.Contains(r.Err.Error(), "negative: -1") {
+				t.Fatalf("result 7 error = %v", r.Err)
+			}
+		case 9:
+			if r.Err == nil || !strings.Contains(r.Err.Error(), "not a supported starlark type") {
+				t.Fatalf("result 9 error = %v", r.Err)
+			}
+		default:
+			if r.Err != nil {
+				t.Fatalf("result %d: %v", i, r.Err)
+			}
+			if got := fmt.Sprint(r.Globals["out"]); got != fmt.Sprint([]interface{}{int64(2 * i), int64(0)}) {
+				t.Fatalf("result %d out = %v", i, got)
+			}
+		}
+	}
+	if p := atomic.LoadInt32(&peak); p > 3 {
+		t.Fatalf("peak concurrency %d exceeds the limit of 3", p)
+	}
+}
+
+func TestRunBatchErrors(t *testing.T) {
+	dir := writeScripts(t, map[string]string{"bad.star": "out = y\n", "ok.star": "out = 1\n"})
+	c := New(dir)
+
+	// a compile error is reported per input
+	res, err := c.RunBatch("bad.star", []map[string]interface{}{{"y": 1}, {}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res[0].Err != nil || res[0].Globals["out"] != int64(1) {
+		t.Fatalf("result 0 = %+v", res[0])
+	}
+	if res[1].Err == nil || !strings.Contains(res[1].Err.Error(), "undefined: y") {
+		t.Fatalf("result 1 error = %v", res[1].Err)
+	}
+
+	// a bad shared global fails the whole batch
+	if _, err := c.RunBatch("ok.star", []map[string]interface{}{{}}, &BatchOptions{Shared: map[string]interface{}{"c": uintptr(1)}}); err == nil {
+		t.Fatal("expected an error for an unconvertible shared global")
+	}
+
+	// a cancelled context fails every input
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	res, err = c.RunBatch("ok.star", make([]map[string]interface{}, 5), &BatchOptions{Context: ctx})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range res {
+		if r.Err != context.Canceled {
+			t.Fatalf("result %d error = %v, want context.Canceled", i, r.Err)
+		}
+	}
+
+	// an empty batch is fine
+	if res, err := c.RunBatch("ok.star", nil, nil); err != nil || len(res) != 0 {
+		t.Fatalf("empty batch = %v, %v", res, err)
+	}
+}
+
+// countingLoader counts how often a Cache goes back to its scripts.
+type countingLoader struct {
+	MapLoader
+	calls int32
+}
+
+func (l *countingLoader) Load(name string) ([]byte, string, error) {
+	atomic.AddInt32(&l.calls, 1)
+	return l.MapLoader.Load(name)
+}
+
+func (l *countingLoader) Version(name string) (string, error) {
+	atomic.AddInt32(&l.calls, 1)
+	return l.MapLoader.Version(name)
+}
+
+// TestRunBatchResolvesOnce verifies RunBatch consults the loader once per
+// input, running the program it resolved before the fan-out.
+func TestRunBatchResolvesOnce(t *testing.T) {
+	l := &countingLoader{MapLoader: MapLoader{"double.star": "out = x * 2\n"}}
+	c, err := WithLoader(l, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := []map[string]interface{}{{"x": 1}, {"x": 2}, {"x": 3}}
+	res, err := c.RunBatch("double.star", inputs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range res {
+		if r.Err != nil || r.Globals["out"] != int64(2*(i+1)) {
+			t.Fatalf("result %d = %v, %v", i, r.Globals["out"], r.Err)
+		}
+	}
+	if n := atomic.LoadInt32(&l.calls); n != int32(len(inputs)) {
+		t.Errorf("loader consulted %d times, want %d", n, len(inputs))
+	}
+}
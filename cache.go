@@ -22,6 +22,13 @@ type cache struct {
 	cache    map[string]*entry
 	globals  starlark.StringDict
 	readFile func(s string) ([]byte, error)
+	// program, when set, supplies the compiled (and cached) program for a
+	// module under the given predeclared globals; modules are then run via
+	// Program.Init instead of being re-read and re-compiled on each load.
+	program func(module string, globals starlark.StringDict) (*starlark.Program, error)
+	// setup, when set, prepares each module-loading thread (thread-locals,
+	// cancellation) before the module runs.
+	setup func(thread *starlark.Thread)
 }
 
 type entry struct {
@@ -88,11 +95,29 @@ func (c *cache) doLoad(cc *cycleChecker, module string) (starlark.StringDict, er
 			return c.get(cc, module)
 		},
 	}
+	predeclared := c.globals
+	if c.setup != nil {
+		c.setup(thread)
+		predeclared = bindThread(predeclared, thread)
+	}
+	if c.program != nil {
+		p, err := c.program(module, predeclared)
+		if err != nil {
+			return nil, err
+		}
+		// frozen like ExecFileOptions does, as modules may be shared
+		globals, err := p.Init(thread, predeclared)
+		if err != nil {
+			return nil, err
+		}
+		globals.Freeze()
+		return globals, nil
+	}
 	b, err := c.readFile(module)
 	if err != nil {
 		return nil, err
 	}
-	return starlark.ExecFileOptions(dialectOptions, thread, module, b, c.globals)
+	return starlark.ExecFileOptions(dialectOptions, thread, module, b, predeclared)
 }
 
 // -- concurrent cycle checking --
@@ -0,0 +1,109 @@
+package starlight
+
+import (
+	"fmt"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+// OverridePolicy decides what a chain of scripts does when a file defines a
+// global an earlier file of the chain already defined.
+type OverridePolicy int
+
+const (
+	// AllowOverride lets later files override earlier ones silently.
+	AllowOverride OverridePolicy = iota
+	// WarnOverride lets later files override earlier ones, and records a
+	// warning for each override in ChainResult.Warnings.
+	WarnOverride
+	// ErrorOverride fails the chain at the first override.
+	ErrorOverride
+)
+
+// ChainResult is the outcome of EvalFiles and Cache.RunChain.
+type ChainResult struct {
+	// Globals holds the merged globals of all files, converted as by
+	// convert.FromStringDict and shaped by the run options.
+	Globals map[string]interface{}
+	// Provenance maps every name in Globals to the last file of the chain
+	// that defined it.
+	Provenance map[string]string
+	// Warnings lists the overrides made under WarnOverride, in order.
+	Warnings []string
+}
+
+// EvalFiles runs the script files in order as a single namespace: each file
+// sees the given globals plus the globals defined by the files before it,
+// frozen as those of a load()ed module are, and the merged globals are
+// returned with the file each name came from. When a file defines a name
+// an earlier file defined, policy decides whether that is allowed. The
+// files run as one run configured by opts, and the result-shaping options
+// apply to the merged globals.
+func EvalFiles(files []string, globals map[string]interface{}, load LoadFunc, policy OverridePolicy, opts ...RunOption) (*ChainResult, error) {
+	rc := newRunConfig(opts)
+	dict, err := rc.makeDict(globals)
+	if err != nil {
+		return nil, err
+	}
+	return runChain(files, dict, policy, rc, func(rs *runState, file string, predeclared starlark.StringDict) (starlark.StringDict, error) {
+		thread := rs.newThread(load)
+		return starlark.ExecFileOptions(dialectOptions, thread, file, nil, bindThread(predeclared, thread))
+	})
+}
+
+// RunChain is like EvalFiles for scripts found in the cache's directories:
+// every file is compiled and cached as by Run, under the names it can see.
+func (c *Cache) RunChain(files []string, globals map[string]interface{}, policy OverridePolicy, opts ...RunOption) (*ChainResult, error) {
+	rc := c.runConfig(opts)
+	dict, err := rc.makeDict(globals)
+	if err != nil {
+		return nil, err
+	}
+	return runChain(files, dict, policy, rc, func(rs *runState, file string, predeclared starlark.StringDict) (starlark.StringDict, error) {
+		return c.execIn(rs, file, predeclared)
+	})
+}
+
+// runChain runs files in order with exec, accumulating their globals.
+func runChain(files []string, dict starlark.StringDict, policy OverridePolicy, rc *runConfig,
+	exec func(rs *runState, file string, predeclared starlark.StringDict) (starlark.StringDict, error)) (_ *ChainResult, err error) {
+	rs, err := rc.start()
+	if err != nil {
+		return nil, err
+	}
+	defer rs.finish(&err)
+
+	res := &ChainResult{Provenance: make(map[string]string)}
+	merged := make(starlark.StringDict)
+	for _, file := range files {
+		ret, err := exec(rs, file, mergeDicts(dict, merged))
+		if err != nil {
+			return nil, err
+		}
+		// later files see the globals of a file frozen, as a load()ed
+		// module's, whichever way the file was run
+		ret.Freeze()
+		for _, name := range ret.Keys() {
+			if prev, ok := res.Provenance[name]; ok {
+				msg := fmt.Sprintf("%s: overrides %q defined by %s", file, name, prev)
+				switch policy {
+				case ErrorOverride:
+					return nil, fmt.Errorf("starlight: %s", msg)
+				case WarnOverride:
+					res.Warnings = append(res.Warnings, msg)
+				}
+			}
+			merged[name] = ret[name]
+			res.Provenance[name] = file
+		}
+	}
+	shaped := rc.filter.apply(merged, dict)
+	for name := range res.Provenance {
+		if _, ok := shaped[name]; !ok {
+			delete(res.Provenance, name)
+		}
+	}
+	res.Globals = convert.FromStringDict(shaped)
+	return res, nil
+}
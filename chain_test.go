@@ -0,0 +1,105 @@
+package starlight
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var chainScripts = map[string]string{
+	"base.star":      "name = \"svc\"\nport = 80\nreplicas = 1\ndef _double(x):\n    return x * 2\n",
+	"overrides.star": "port = 8080\nworkers = _double(replicas) + extra\n",
+	"local.star":     "replicas = 3\ndebug = env == \"dev\"\n",
+}
+
+// TestRunChain verifies files see the globals of the files before them,
+// and the merged globals carry their provenance.
+func TestRunChain(t *testing.T) {
+	dir := writeScripts(t, chainScripts)
+	files := []string{"base.star", "overrides.star", "local.star"}
+	globals := map[string]interface{}{"env": "dev", "extra": 1}
+
+	res, err := New(dir).RunChain(files, globals, AllowOverride)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"name": "svc", "port": int64(8080), "replicas": int64(3), "workers": int64(3), "debug": true}
+	for k, v := range want {
+		if res.Globals[k] != v {
+			t.Errorf("%s = %v, want %v", k, res.Globals[k], v)
+		}
+	}
+	prov := map[string]string{"name": "base.star", "port": "overrides.star", "replicas": "local.star", "workers": "overrides.star", "debug": "local.star", "_double": "base.star"}
+	for k, v := range prov {
+		if res.Provenance[k] != v {
+			t.Errorf("provenance of %s = %q, want %q", k, res.Provenance[k], v)
+		}
+	}
+	if len(res.Warnings) != 0 {
+		t.Errorf("unexpected warnings %v", res.Warnings)
+	}
+
+	// result shaping applies to the merged globals and their provenance
+	res, err = New(dir).RunChain(files, globals, WarnOverride, ExcludeCallables(), ExcludePrivate())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.Globals["_double"]; ok {
+		t.Error("_double was not filtered out")
+	}
+	if _, ok := res.Provenance["_double"]; ok {
+		t.Error("_double still has a provenance")
+	}
+	if len(res.Warnings) != 2 ||
+		res.Warnings[0] != `overrides.star: overrides "port" defined by base.star` ||
+		res.Warnings[1] != `local.star: overrides "replicas" defined by base.star` {
+		t.Errorf("warnings = %q", res.Warnings)
+	}
+
+	_, err = New(dir).RunChain(files, globals, ErrorOverride)
+	if err == nil || !strings.Contains(err.Error(), `overrides.star: overrides "port" defined by base.star`) {
+		t.Fatalf("expected an override error, got %v", err)
+	}
+
+	// a failing file fails the chain
+	_, err = New(dir).RunChain([]string{"overrides.star"}, globals, AllowOverride)
+	if err == nil || !strings.Contains(err.Error(), "undefined: _double") {
+		t.Fatalf("expected a resolve error, got %v", err)
+	}
+}
+
+// TestEvalFiles verifies EvalFiles chains files read from disk.
+func TestEvalFiles(t *testing.T) {
+	dir := writeScripts(t, chainScripts)
+	var files []string
+	for _, f := range []string{"base.star", "overrides.star", "local.star"} {
+		files = append(files, filepath.Join(dir, f))
+	}
+	res, err := EvalFiles(files, map[string]interface{}{"env": "prod", "extra": 0}, nil, AllowOverride)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Globals["workers"] != int64(2) || res.Globals["debug"] != false {
+		t.Fatalf("globals = %v", res.Globals)
+	}
+	if res.Provenance["port"] != files[1] {
+		t.Errorf("provenance of port = %q", res.Provenance["port"])
+	}
+}
+
+// TestChainFreezes verifies later files of a chain cannot mutate the
+// globals of earlier ones, whether the chain runs through a Cache or not.
+func TestChainFreezes(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"a.star": "cfg = {}\n",
+		"b.star": "cfg['x'] = 1\n",
+	})
+	_, err := New(dir).RunChain([]string{"a.star", "b.star"}, nil, AllowOverride)
+	if err == nil || !strings.Contains(err.Error(), "frozen") {
+		t.Errorf("RunChain: expected a frozen error, got %v", err)
+	}
+	_, err = EvalFiles([]string{filepath.Join(dir, "a.star"), filepath.Join(dir, "b.star")}, nil, nil, AllowOverride)
+	if err == nil || !strings.Contains(err.Error(), "frozen") {
+		t.Errorf("EvalFiles: expected a frozen error, got %v", err)
+	}
+}
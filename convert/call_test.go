@@ -303,7 +303,7 @@ test()
 		},
 		{
 			name:    "unsupported type",
-			goValue: make(chan bool),
+			goValue: uintptr(1),
 			codeSnippet: `
 print('※ go_value: {}({})'.format(go_value, type(go_value)))
 `,
@@ -428,9 +428,9 @@ func TestCallGoFunctionInStarlark(t *testing.T) {
 			wantErrExec: true,
 		},
 		{
-			name: "unsupported func(int) chan",
-			goFunc: func(size int) chan int {
-				return make(chan int, size)
+			name: "unsupported func(int) uintptr",
+			goFunc: func(size int) uintptr {
+				return uintptr(size)
 			},
 			codeSnippet: `sl_value = go_func(42)`,
 			wantErrExec: true,
@@ -841,10 +841,9 @@ func TestCustomStructInStarlark(t *testing.T) {
 			wantErrExec: true,
 		},
 		{
-			name:        "access unsupported field",
-			codeSnippet: `foo = pn.number_chan`,
-			checkEqual:  noCheck,
-			wantErrExec: true,
+			name:        "access channel field",
+			codeSnippet: `pn.number_chan.send(5); foo = str(pn.number_chan.recv()); out = pn`,
+			checkEqual:  getStringCompare("foo", "(5, True)"),
 		},
 		{
 			name:        "access unsupported field 2",
@@ -0,0 +1,152 @@
+package convert
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"go.starlark.net/starlark"
+)
+
+// callbacks adapts the Starlark callables passed to one call of a wrapped
+// Go function into the Go func types of its parameters. The adapted funcs
+// call back into Starlark on the calling thread, so the Go function must
+// call them on its own goroutine and only until it returns.
+type callbacks struct {
+	thread  *starlark.Thread
+	name    string // the wrapped Go function
+	tagName string
+	sc      *scope
+
+	mu   sync.Mutex
+	done bool  // set once the call returned
+	err  error // the first error of a callback with no error result
+}
+
+// newCallbacks returns the adapter of the callbacks of a call of the Go
+// function name on thread. Defer its finish method once it is created.
+func newCallbacks(thread *starlark.Thread, name, tagName string, sc *scope) *callbacks {
+	return &callbacks{thread: thread, name: name, tagName: tagName, sc: sc}
+}
+
+// finish ends the call, failing it through *err with the error of a
+// callback that had no error result to return it through, if any.
+func (c *callbacks) finish(err *error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.done = true
+	if c.err != nil {
+		*err = c.err
+	}
+}
+
+// state returns whether the call returned, and the error recorded by a
+// failed callback.
+func (c *callbacks) state() (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.done, c.err
+}
+
+// record keeps err as the error of the call, unless the call returned or
+// failed already.
+func (c *callbacks) record(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.done && c.err == nil {
+		c.err = err
+	}
+}
+
+// adapt returns v as a Go func of type t if v is a Starlark callable and t
+// a func type. Calling the result converts its arguments with ToValue,
+// calls v and converts the result back as arguments are converted; a
+// Starlark result of several values must be a tuple. If v fails, the error
+// is returned as the last result when t has an error result. Otherwise the
+// result holds zero values and the call of the wrapped Go function fails
+// with the error once it returns; later callbacks of the call then return
+// zero values too, without calling Starlark. A callback with no error
+// result called after the Go function returned, maybe from a goroutine it
+// started, only returns zero values, as it has no call left to fail.
+func (c *callbacks) adapt(v starlark.Value, t reflect.Type) (reflect.Value, bool) {
+	if c == nil || t.Kind() != reflect.Func {
+		return reflect.Value{}, false
+	}
+	fn, ok := v.(starlark.Callable)
+	if !ok {
+		return reflect.Value{}, false
+	}
+	nres := t.NumOut()
+	hasErr := nres > 0 && t.Out(nres-1) == errType
+	if hasErr {
+		nres--
+	}
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(t.Out(i))
+		}
+		fail := func(err error) []reflect.Value {
+			err = fmt.Errorf("%s: callback %s: %w", c.name, fn.Name(), err)
+			if !hasErr {
+				c.record(err)
+				return out
+			}
+			out[len(out)-1] = reflect.ValueOf(&err).Elem()
+			return out
+		}
+		done, err := c.state()
+		if done {
+			return fail(fmt.Errorf("called after %s returned", c.name))
+		}
+		if err != nil {
+			if hasErr {
+				out[len(out)-1] = reflect.ValueOf(&err).Elem()
+			}
+			return out
+		}
+
+		args := make(starlark.Tuple, len(in))
+		for i, a := range in {
+			sv, err := toValue(a, c.tagName, c.sc)
+			if err != nil {
+				return fail(fmt.Errorf("arg %d: %v", i, err))
+			}
+			args[i] = sv
+		}
+		ret, err := starlark.Call(c.thread, fn, args, nil)
+		if err != nil {
+			return fail(err)
+		}
+
+		results := starlark.Tuple{ret}
+		switch nres {
+		case 0:
+			return out
+		case 1:
+		default:
+			tup, ok := ret.(starlark.Tuple)
+			if !ok || len(tup) != nres {
+				return fail(fmt.Errorf("got %s, want a tuple of %d values", ret.Type(), nres))
+			}
+			results = tup
+		}
+		for i, r := range results {
+			rv, ok, err := c.sc.fromRegistered(r, t.Out(i))
+			if !ok {
+				rv, err = convertReflectValue(reflect.ValueOf(FromValue(r)), t.Out(i), c.sc)
+			}
+			if err != nil {
+				return fail(fmt.Errorf("result %d: %v", i, err))
+			}
+			out[i] = rv
+		}
+		return out
+	}), true
+}
+
+// panicError returns the error of a call of the wrapped Go function name
+// that panicked with r.
+func panicError(name string, r interface{}) error {
+	return fmt.Errorf("panic in func %s: %v", name, r)
+}
@@ -0,0 +1,148 @@
+package convert_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+type order struct {
+	ID    int
+	Total float64
+}
+
+// TestCallbacks verifies scripts can pass def and lambda callables for Go
+// parameters of func type.
+func TestCallbacks(t *testing.T) {
+	orders := []order{{1, 10}, {2, 250}, {3, 99.5}}
+	var kept func() error
+	envs, err := convert.MakeStringDict(map[string]interface{}{
+		"filter": func(keep func(o order) bool) []int {
+			var ids []int
+			for _, o := range orders {
+				if keep(o) {
+					ids = append(ids, o.ID)
+				}
+			}
+			return ids
+		},
+		"fold": func(init int, step func(acc, n int) (int, error), ns ...int) (int, error) {
+			acc := init
+			for _, n := range ns {
+				var err error
+				if acc, err = step(acc, n); err != nil {
+					return 0, err
+				}
+			}
+			return acc, nil
+		},
+		"split": func(f func(s string) (string, string)) string {
+			a, b := f("k=v")
+			return b + "=" + a
+		},
+		"keep":  func(f func() error) { kept = f },
+		"apply": convert.Named(func(x int, f func(int) int) int { return f(x) }, "x", "f"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := execWithThread(&starlark.Thread{}, `
+def big(o):
+    return o.Total > 50
+def split_kv(s):
+    k, v = s.split("=")
+    return k, v
+out = [
+    filter(big),
+    filter(lambda o: o.ID == 1),
+    fold(0, lambda acc, n: acc + n, 1, 2, 3),
+    split(split_kv),
+    apply(f=lambda n: n * n, x=7),
+]
+keep(lambda: None)
+`, envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res["out"].String(); got != `[[2 3], [1], 6, "v=k", 49]` {
+		t.Fatalf("out = %s", got)
+	}
+	if err := kept(); err == nil || !strings.Contains(err.Error(), "called after keep returned") {
+		t.Fatalf("expected an expired callback error, got %v", err)
+	}
+
+	for script, msg := range map[string]string{
+		// an error result receives the callback's error
+		`fold(0, lambda acc, n: acc // n, 1, 0)`: "fold: callback lambda: floored division by zero",
+		// other signatures fail the call cleanly
+		`filter(lambda o: o.Missing)`: "filter: callback lambda:",
+		`filter(lambda o: "yes")`:     "filter: callback lambda: result 0:",
+		`split(lambda s: s)`:          "want a tuple of 2 values",
+	} {
+		_, err := execWithThread(&starlark.Thread{}, script, envs)
+		if err == nil || !strings.Contains(err.Error(), msg) || strings.Contains(err.Error(), "panic") {
+			t.Errorf("%s: expected %q, got %v", script, msg, err)
+		}
+	}
+}
+
+// TestCallbackErrorChain verifies a callback error returned to Go keeps the
+// Starlark error in its chain.
+func TestCallbackErrorChain(t *testing.T) {
+	var got error
+	envs := starlark.StringDict{
+		"attempt": convert.MakeStarFn("attempt", func(f func() error) { got = f() }),
+	}
+	if _, err := execWithThread(&starlark.Thread{}, `attempt(lambda: fail("boom"))`, envs); err != nil {
+		t.Fatal(err)
+	}
+	var evalErr *starlark.EvalError
+	if !errors.As(got, &evalErr) || !strings.Contains(fmt.Sprint(got), "boom") {
+		t.Fatalf("callback error = %v", got)
+	}
+}
+
+// TestCallbackOutsideCall verifies callbacks with no error result neither
+// call Starlark nor panic once the Go function returned, and that one
+// failing on another goroutine fails the call.
+func TestCallbackOutsideCall(t *testing.T) {
+	var registered func(int) bool
+	envs, err := convert.MakeStringDict(map[string]interface{}{
+		"reg": func(f func(int) bool) { registered = f },
+		"spawn": func(f func(int) bool) bool {
+			ch := make(chan bool)
+			go func() { ch <- f(1) }()
+			return <-ch
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := execWithThread(&starlark.Thread{}, `
+calls = []
+def even(n):
+    calls.append(n)
+    return n % 2 == 0
+reg(even)
+`, envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	go func() { done <- registered(2) }()
+	if <-done || registered(4) {
+		t.Error("expired callback returned true")
+	}
+	if got := res["calls"].String(); got != "[]" {
+		t.Errorf("expired callback ran Starlark: calls = %s", got)
+	}
+
+	_, err = execWithThread(&starlark.Thread{}, `spawn(lambda n: n // 0)`, envs)
+	if err == nil || !strings.Contains(err.Error(), "spawn: callback lambda: floored division by zero") {
+		t.Errorf("expected the callback error, got %v", err)
+	}
+}
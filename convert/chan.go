@@ -0,0 +1,280 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"go.starlark.net/starlark"
+)
+
+// GoChan wraps a Go channel for use with Starlark. Depending on the
+// direction of the channel, scripts can call:
+//
+//	v, ok = ch.recv()      # blocks; ok is False once ch is closed and drained
+//	v, ok = ch.try_recv()  # ok is False if no value is ready or ch is closed
+//	ch.send(v)             # blocks; fails on a closed channel
+//	ch.close()
+//
+// and receive values with "for v in ch" until the channel is closed. Sent
+// values are converted to the element type as struct fields are, and
+// received ones as by ToValue.
+//
+// recv and send stop waiting, failing, once the context of the calling
+// thread (see ThreadContext) is done, so a script blocked on a channel can be
+// cancelled. Starlark iterators do not see the calling thread, so a for loop
+// waits on the context of the thread the channel is bound to (see
+// BindThread), and on the context given to WithContext, if any, and ends
+// early when one is done; the run then fails at its next step, the bound
+// thread being cancelled once its context is done. A received value that
+// cannot be converted ends the loop too, cancelling the bound thread with
+// the conversion error, and so does a loop over a send-only or nil channel,
+// which could never yield a value.
+type GoChan struct {
+	_   DoNotCompare
+	v   reflect.Value
+	tag string
+	sc  *scope
+	ctx context.Context
+}
+
+var (
+	_ starlark.Iterable = (*GoChan)(nil)
+	_ starlark.HasAttrs = (*GoChan)(nil)
+)
+
+// NewGoChan wraps the given channel in a new GoChan.
+// This function will panic if ch is not a channel, or if its element type
+// cannot be converted to Starlark — the same static check ToValue applies.
+func NewGoChan(ch interface{}) *GoChan {
+	v := reflect.ValueOf(ch)
+	if v.Kind() != reflect.Chan {
+		panic(fmt.Errorf("NewGoChan expects a channel, not %T", ch))
+	}
+	if err := checkCollectionElemTypesCached(v.Type()); err != nil {
+		panic(err)
+	}
+	return &GoChan{v: v}
+}
+
+// WithContext returns a copy of g whose for loops stop waiting for values
+// once ctx is done, as well as once its run context is.
+func (g *GoChan) WithContext(ctx context.Context) *GoChan {
+	return &GoChan{v: g.v, tag: g.tag, sc: g.sc, ctx: ctx}
+}
+
+// Value returns reflect.Value of the underlying channel.
+func (g *GoChan) Value() reflect.Value {
+	return g.v
+}
+
+// String returns the string representation of the value.
+func (g *GoChan) String() string {
+	return safeGoString(g.v)
+}
+
+// Type returns a short string describing the value's type.
+func (g *GoChan) Type() string {
+	return fmt.Sprintf("starlight_chan<%s>", g.v.Type())
+}
+
+// Freeze is a no-op: a channel is safe for concurrent use, and freezing it
+// would not stop the host from sending to it or closing it anyway.
+func (g *GoChan) Freeze() {}
+
+// Truth returns false for a nil channel.
+func (g *GoChan) Truth() starlark.Bool {
+	return starlark.Bool(!g.v.IsNil())
+}
+
+// Hash returns an error: channels are not hashable.
+func (g *GoChan) Hash() (uint32, error) {
+	return 0, errors.New("starlight_chan is not hashable")
+}
+
+func (g *GoChan) canRecv() bool {
+	return g.v.Type().ChanDir()&reflect.RecvDir != 0
+}
+
+func (g *GoChan) canSend() bool {
+	return g.v.Type().ChanDir()&reflect.SendDir != 0
+}
+
+// Attr returns the channel methods its direction allows.
+func (g *GoChan) Attr(name string) (starlark.Value, error) {
+	var impl func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error)
+	switch {
+	case name == "recv" && g.canRecv():
+		impl = g.recv
+	case name == "try_recv" && g.canRecv():
+		impl = g.tryRecv
+	case name == "send" && g.canSend():
+		impl = g.send
+	case name == "close" && g.canSend():
+		impl = g.close
+	default:
+		return nil, nil // no such method
+	}
+	return starlark.NewBuiltin(name, impl).BindReceiver(g), nil
+}
+
+// AttrNames returns the names of the channel methods its direction allows.
+func (g *GoChan) AttrNames() []string {
+	var names []string
+	if g.canSend() {
+		names = append(names, "close")
+	}
+	if g.canRecv() {
+		names = append(names, "recv")
+	}
+	if g.canSend() {
+		names = append(names, "send")
+	}
+	if g.canRecv() {
+		names = append(names, "try_recv")
+	}
+	return names
+}
+
+// received returns the (value, ok) tuple of a receive.
+func (g *GoChan) received(x reflect.Value, ok bool) (starlark.Value, error) {
+	if !ok {
+		return starlark.Tuple{starlark.None, starlark.False}, nil
+	}
+	v, err := toValue(x, g.tag, g.sc)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Tuple{v, starlark.True}, nil
+}
+
+func (g *GoChan) recv(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	chosen, x, ok := g.wait(ThreadContext(thread), reflect.SelectCase{Dir: reflect.SelectRecv, Chan: g.v})
+	if chosen != 0 {
+		return nil, fmt.Errorf("%s: %w", b.Name(), g.waitErr(thread))
+	}
+	return g.received(x, ok)
+}
+
+func (g *GoChan) tryRecv(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	x, ok := g.v.TryRecv()
+	return g.received(x, ok)
+}
+
+func (g *GoChan) send(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (sv starlark.Value, err error) {
+	var v starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &v); err != nil {
+		return nil, err
+	}
+	x, err := tryConv(v, g.v.Type().Elem(), g.sc)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			// a send on a closed channel
+			sv, err = nil, fmt.Errorf("%s: %v", b.Name(), r)
+		}
+	}()
+	if chosen, _, _ := g.wait(ThreadContext(thread), reflect.SelectCase{Dir: reflect.SelectSend, Chan: g.v, Send: x}); chosen != 0 {
+		return nil, fmt.Errorf("%s: %w", b.Name(), g.waitErr(thread))
+	}
+	return starlark.None, nil
+}
+
+func (g *GoChan) close(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (sv starlark.Value, err error) {
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			// closing a nil or closed channel
+			sv, err = nil, fmt.Errorf("%s: %v", b.Name(), r)
+		}
+	}()
+	g.v.Close()
+	return starlark.None, nil
+}
+
+// wait runs op on the channel until it proceeds, or ctx, the run context
+// or the context of g is done; it returns the index of the chosen case, 0
+// for op, and the received value.
+func (g *GoChan) wait(ctx context.Context, op reflect.SelectCase) (int, reflect.Value, bool) {
+	cases := []reflect.SelectCase{op}
+	for _, c := range []context.Context{ctx, g.sc.runContext(), g.ctx} {
+		if c != nil && c.Done() != nil {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(c.Done())})
+		}
+	}
+	return reflect.Select(cases)
+}
+
+// waitErr returns why a wait on the channel by thread was given up.
+func (g *GoChan) waitErr(thread *starlark.Thread) error {
+	if err := ThreadContext(thread).Err(); err != nil {
+		return err
+	}
+	if ctx := g.sc.runContext(); ctx != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if g.ctx != nil && g.ctx.Err() != nil {
+		return g.ctx.Err()
+	}
+	return context.Canceled
+}
+
+// Iterate returns an iterator receiving the values of the channel until it
+// is closed, or its run context or the context of g is done.
+func (g *GoChan) Iterate() starlark.Iterator {
+	return &chanIterator{g: g}
+}
+
+type chanIterator struct {
+	g *GoChan
+}
+
+func (it *chanIterator) Next(p *starlark.Value) bool {
+	switch {
+	case !it.g.canRecv():
+		return it.fail("cannot iterate over a send-only channel")
+	case it.g.v.IsNil():
+		// receiving would block until the run ends
+		return it.fail("cannot iterate over a nil channel")
+	}
+	chosen, x, ok := it.g.wait(nil, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: it.g.v})
+	if chosen != 0 {
+		if ctx := it.g.sc.runContext(); ctx != nil && ctx.Err() != nil {
+			// the next step of the loop fails, instead of the loop
+			// ending as if the channel was closed
+			it.g.sc.boundThread().Cancel(ctx.Err().Error())
+		}
+		return false
+	}
+	if !ok {
+		return false
+	}
+	v, err := toValue(x, it.g.tag, it.g.sc)
+	if err != nil {
+		return it.fail(err.Error())
+	}
+	*p = v
+	return true
+}
+
+// fail ends the loop with the error msg. Iterators cannot fail, so the run
+// fails at its next step through the bound thread, if any.
+func (it *chanIterator) fail(msg string) bool {
+	if th := it.g.sc.boundThread(); th != nil {
+		th.Cancel(fmt.Sprintf("%s: %s", it.g.Type(), msg))
+	}
+	return false
+}
+
+func (it *chanIterator) Done() {}
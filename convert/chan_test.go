@@ -0,0 +1,238 @@
+package convert_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+type event struct {
+	Name string
+	Seq  int
+}
+
+// TestGoChanRecv verifies scripts receive from channels by iterating and
+// with recv and try_recv.
+func TestGoChanRecv(t *testing.T) {
+	ch := make(chan event, 3)
+	ch <- event{"a", 1}
+	ch <- event{"b", 2}
+	ch <- event{"c", 3}
+	close(ch)
+	var events <-chan event = ch
+
+	globals, err := convert.MakeStringDict(map[string]interface{}{"events": events})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := execWithThread(&starlark.Thread{}, `
+def drain():
+    first, ok = events.recv()
+    names = [first.Name]
+    for e in events:
+        names.append(e.Name)
+    return names
+
+names = drain()
+last = events.recv()
+polled = events.try_recv()
+methods = dir(events)
+`, globals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"names":   `["a", "b", "c"]`,
+		"last":    `(None, False)`,
+		"polled":  `(None, False)`,
+		"methods": `["recv", "try_recv"]`,
+	} {
+		if got := res[name].String(); got != want {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+
+	if _, err := execWithThread(&starlark.Thread{}, `events.send(1)`, globals); err == nil || !strings.Contains(err.Error(), "no .send") {
+		t.Errorf("send on a receive-only channel = %v", err)
+	}
+}
+
+// TestGoChanSend verifies scripts send to and close channels, with values
+// converted to the element type.
+func TestGoChanSend(t *testing.T) {
+	ch := make(chan int, 2)
+	var out chan<- int = ch
+	globals, err := convert.MakeStringDict(map[string]interface{}{"out": out, "both": ch})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := execWithThread(&starlark.Thread{}, `
+out.send(4)
+both.send(5)
+out.close()
+`, globals); err != nil {
+		t.Fatal(err)
+	}
+	var got []int
+	for n := range ch {
+		got = append(got, n)
+	}
+	if len(got) != 2 || got[0] != 4 || got[1] != 5 {
+		t.Errorf("received %v, want [4 5]", got)
+	}
+
+	for script, want := range map[string]string{
+		`out.send(1)`:    "send on closed channel",
+		`out.close()`:    "close of closed channel",
+		`both.send("x")`: "send:",
+		`out.recv()`:     "no .recv",
+	} {
+		if _, err := execWithThread(&starlark.Thread{}, script, globals); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s = %v, want error containing %q", script, err, want)
+		}
+	}
+}
+
+// TestGoChanCancel verifies a script blocked on a channel stops once its
+// context is done.
+func TestGoChanCancel(t *testing.T) {
+	ch := make(chan int)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	thread := &starlark.Thread{}
+	thread.SetLocal(convert.ContextKey, ctx)
+	globals := starlark.StringDict{"ch": convert.NewGoChan(ch)}
+	_, err := execWithThread(thread, `ch.recv()`, globals)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("recv = %v, want the context error", err)
+	}
+	_, err = execWithThread(thread, `ch.send(1)`, globals)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("send = %v, want the context error", err)
+	}
+
+	// for loops wait on the context given to WithContext
+	globals["ch"] = convert.NewGoChan(ch).WithContext(ctx)
+	res, err := execWithThread(&starlark.Thread{}, `
+def count():
+    n = 0
+    for x in ch:
+        n += 1
+    return n
+
+n = count()
+`, globals)
+	if err != nil || res["n"] != starlark.MakeInt(0) {
+		t.Errorf("for loop = %v, %v", res["n"], err)
+	}
+
+	// and on the context of the thread the channel is bound to, with
+	// BindThread or by being returned by a Go function called on the
+	// thread; the loop cancels the thread
+	globals["open"] = convert.MakeStarFn("open", func() chan int { return ch })
+	for _, src := range []string{"ch", "open()"} {
+		// a cancelled thread stays cancelled, so each loop gets its own
+		thread := &starlark.Thread{}
+		thread.SetLocal(convert.ContextKey, ctx)
+		globals["ch"] = convert.BindThread(convert.NewGoChan(ch), thread)
+		_, err := execWithThread(thread, `
+def count():
+    for x in `+src+`:
+        pass
+
+count()
+`, globals)
+		if err == nil || !strings.Contains(err.Error(), "context deadline exceeded") {
+			t.Errorf("for loop over %s = %v, want the context error", src, err)
+		}
+	}
+}
+
+type reading int
+
+// TestGoChanConvertError verifies a received value that cannot be
+// converted ends a for loop, failing the run through the bound thread.
+func TestGoChanConvertError(t *testing.T) {
+	r := convert.NewTypeRegistry()
+	r.RegisterType(reflect.TypeOf(reading(0)), func(v reflect.Value) (starlark.Value, error) {
+		if v.Int() < 0 {
+			return nil, fmt.Errorf("negative reading %d", v.Int())
+		}
+		return starlark.MakeInt64(v.Int()), nil
+	}, nil)
+	fill := func() starlark.Value {
+		ch := make(chan reading, 3)
+		ch <- 5
+		ch <- -1
+		ch <- 7
+		close(ch)
+		readings, err := r.ToValue((<-chan reading)(ch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return readings
+	}
+	const src = `
+def sum_all():
+    total = 0
+    for x in readings:
+        total += x
+    return total
+
+total = sum_all()
+`
+	thread := &starlark.Thread{}
+	_, err := execWithThread(thread, src, starlark.StringDict{"readings": convert.BindThread(fill(), thread)})
+	if err == nil || !strings.Contains(err.Error(), "negative reading -1") {
+		t.Errorf("bound loop = %v, want the conversion error", err)
+	}
+
+	// with no thread to fail, the loop just ends
+	res, err := execWithThread(&starlark.Thread{}, src, starlark.StringDict{"readings": fill()})
+	if err != nil || res["total"] != starlark.MakeInt(5) {
+		t.Errorf("unbound loop = %v, %v", res["total"], err)
+	}
+}
+
+// TestGoChanIterateInvalid verifies a for loop over a channel it cannot
+// receive from fails the bound run instead of ending silently or blocking.
+func TestGoChanIterateInvalid(t *testing.T) {
+	var nilChan chan int
+	for name, ch := range map[string]interface{}{
+		"send-only": make(chan<- int, 1),
+		"nil":       nilChan,
+	} {
+		v, err := convert.ToValue(ch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thread := &starlark.Thread{}
+		done := make(chan error, 1)
+		go func() {
+			_, err := execWithThread(thread, `
+def count():
+    for x in ch:
+        pass
+
+count()
+`, starlark.StringDict{"ch": convert.BindThread(v, thread)})
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			if want := "cannot iterate over a " + name + " channel"; err == nil || !strings.Contains(err.Error(), want) {
+				t.Errorf("for loop over a %s channel = %v, want an error containing %q", name, err, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("for loop over a %s channel blocked", name)
+		}
+	}
+}
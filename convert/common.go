@@ -1,6 +1,7 @@
 package convert
 
 import (
+	"context"
 	"fmt"
 	"math"
 	"reflect"
@@ -9,6 +10,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"go.starlark.net/starlark"
 )
 
 // DoNotCompare is an embedded zero-sized struct used to disallow comparison operations (== and !=) on the containing struct.
@@ -20,6 +23,8 @@ var (
 	emptyIfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
 	byteType       = reflect.TypeOf(byte(0))
 	durationType   = reflect.TypeOf(time.Duration(0))
+	threadType     = reflect.TypeOf((*starlark.Thread)(nil))
+	contextType    = reflect.TypeOf((*context.Context)(nil)).Elem()
 )
 
 // boundedTypeCache memoizes a per-reflect.Type computation, but stops
@@ -31,7 +36,7 @@ var (
 // realistic small-and-fixed set of host types stays cached while untrusted
 // input cannot pin unbounded memory.
 type boundedTypeCache struct {
-	m   sync.Map // reflect.Type -> stored value (error or bool)
+	m   sync.Map // reflect.Type -> stored value
 	cap int64    // max distinct types to retain
 	n   int64    // current entry count, accessed atomically
 }
@@ -52,6 +57,11 @@ func (c *boundedTypeCache) store(t reflect.Type, v interface{}) {
 	}
 }
 
+// load returns the value cached for t, if any.
+func (c *boundedTypeCache) load(t reflect.Type) (interface{}, bool) {
+	return c.m.Load(t)
+}
+
 // loadOrStore returns the cached error for t if present; otherwise it stores
 // (subject to the cap) and returns compute.
 func (c *boundedTypeCache) loadOrStore(t reflect.Type, compute error) error {
@@ -77,6 +87,15 @@ func (c *boundedTypeCache) loadOrStoreBool(t reflect.Type, compute bool) bool {
 // size reports the number of retained entries (for tests).
 func (c *boundedTypeCache) size() int { return int(atomic.LoadInt64(&c.n)) }
 
+// freezeIf freezes v if frozen is set, so that the wrappers a frozen
+// wrapper returns for the fields and elements of its value are frozen too.
+func freezeIf(v starlark.Value, frozen bool) starlark.Value {
+	if frozen {
+		v.Freeze()
+	}
+	return v
+}
+
 // sortedMapKeys returns the keys of the given map value in a deterministic
 // order: keys are sorted by type rank (nil < bool < int < uint < float <
 // string < other), then by value within the same rank; "other" keys compare
@@ -0,0 +1,201 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"reflect"
+	"strconv"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Complex is the Starlark type of Go complex64 and complex128 values, named
+// "complex" in scripts. It has the real and imag attributes and the conj()
+// and abs() methods, supports the + - * / operators with complex, float
+// and int operands, and compares with == and != only. Starlark compares
+// values of different types as unequal without consulting either, so a
+// complex never equals an int or a float, even with no imaginary part:
+// a complex 1+0i == 1 is False; compare c.real and c.imag instead. FromValue
+// converts it back to a complex128.
+type Complex complex128
+
+var (
+	_ starlark.HasAttrs   = Complex(0)
+	_ starlark.HasBinary  = Complex(0)
+	_ starlark.HasUnary   = Complex(0)
+	_ starlark.Comparable = Complex(0)
+)
+
+// String formats c as Python does, e.g. (1+2j), or 2j if c has no real part.
+func (c Complex) String() string {
+	re, im := real(c), imag(c)
+	fim := strconv.FormatFloat(im, 'g', -1, 64) + "j"
+	if re == 0 && !math.Signbit(re) {
+		return fim
+	}
+	if im >= 0 || math.IsNaN(im) {
+		fim = "+" + fim
+	}
+	return "(" + strconv.FormatFloat(re, 'g', -1, 64) + fim + ")"
+}
+
+// Type returns "complex".
+func (c Complex) Type() string { return "complex" }
+
+// Freeze is a no-op: complex numbers are immutable.
+func (c Complex) Freeze() {}
+
+// Truth reports whether c is not zero.
+func (c Complex) Truth() starlark.Bool { return c != 0 }
+
+// Hash combines the hashes of the real and imaginary parts.
+func (c Complex) Hash() (uint32, error) {
+	hr, err := starlark.Float(real(c)).Hash()
+	if err != nil {
+		return 0, err
+	}
+	hi, err := starlark.Float(imag(c)).Hash()
+	if err != nil {
+		return 0, err
+	}
+	return hr ^ (hi * 1000003), nil
+}
+
+// Attr returns the real or imaginary part, or the conj or abs method.
+func (c Complex) Attr(name string) (starlark.Value, error) {
+	switch name {
+	case "real":
+		return starlark.Float(real(c)), nil
+	case "imag":
+		return starlark.Float(imag(c)), nil
+	case "conj":
+		return starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+				return nil, err
+			}
+			return Complex(cmplx.Conj(complex128(c))), nil
+		}).BindReceiver(c), nil
+	case "abs":
+		return starlark.NewBuiltin(name, func(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+			if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 0); err != nil {
+				return nil, err
+			}
+			return starlark.Float(cmplx.Abs(complex128(c))), nil
+		}).BindReceiver(c), nil
+	}
+	return nil, nil // no such attribute
+}
+
+// AttrNames returns the attributes of complex numbers.
+func (c Complex) AttrNames() []string {
+	return []string{"abs", "conj", "imag", "real"}
+}
+
+// asComplex returns v as a complex number, if it is a number.
+func asComplex(v starlark.Value) (complex128, bool) {
+	switch v := v.(type) {
+	case Complex:
+		return complex128(v), true
+	case starlark.Float:
+		return complex(float64(v), 0), true
+	case starlark.Int:
+		return complex(float64(v.Float()), 0), true
+	}
+	return 0, false
+}
+
+// Binary implements the arithmetic operators with complex, float and int
+// operands.
+func (c Complex) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	z, ok := asComplex(y)
+	if !ok {
+		return nil, nil // unhandled
+	}
+	l, r := complex128(c), z
+	if side == starlark.Right {
+		l, r = r, l
+	}
+	switch op {
+	case syntax.PLUS:
+		return Complex(l + r), nil
+	case syntax.MINUS:
+		return Complex(l - r), nil
+	case syntax.STAR:
+		return Complex(l * r), nil
+	case syntax.SLASH:
+		if r == 0 {
+			return nil, errors.New("complex division by zero")
+		}
+		return Complex(l / r), nil
+	}
+	return nil, nil // unhandled
+}
+
+// Unary implements the unary + and - operators.
+func (c Complex) Unary(op syntax.Token) (starlark.Value, error) {
+	switch op {
+	case syntax.MINUS:
+		return -c, nil
+	case syntax.PLUS:
+		return c, nil
+	}
+	return nil, nil // unhandled
+}
+
+// CompareSameType implements == and != between complex numbers; complex
+// numbers are not ordered, and never equal values of other types.
+func (c Complex) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	z := y.(Complex)
+	switch op {
+	case syntax.EQL:
+		return c == z, nil
+	case syntax.NEQ:
+		return c != z, nil
+	}
+	return false, fmt.Errorf("%s %s %s not implemented (complex numbers are not ordered)", c.Type(), op, y.Type())
+}
+
+// convertComplex converts the number val to the complex type t for
+// checkedConvert, refusing parts out of range for t.
+func convertComplex(val reflect.Value, t reflect.Type) (reflect.Value, error) {
+	var c complex128
+	switch val.Kind() {
+	case reflect.Complex64, reflect.Complex128:
+		c = val.Complex()
+	case reflect.Float32, reflect.Float64:
+		c = complex(val.Float(), 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		c = complex(float64(val.Int()), 0)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		c = complex(float64(val.Uint()), 0)
+	default:
+		return reflect.Value{}, fmt.Errorf("value of type %s cannot be converted to type %s", val.Type(), t)
+	}
+	out := reflect.New(t).Elem()
+	if out.OverflowComplex(c) {
+		return reflect.Value{}, fmt.Errorf("value %v out of range for type %s", c, t)
+	}
+	out.SetComplex(c)
+	return out, nil
+}
+
+// convertibleTo reports whether checkedConvert may convert values of type
+// from to type to: as reflect's ConvertibleTo, and numbers to complex types.
+func convertibleTo(from, to reflect.Type) bool {
+	if from.ConvertibleTo(to) {
+		return true
+	}
+	switch to.Kind() {
+	case reflect.Complex64, reflect.Complex128:
+		switch from.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			return true
+		}
+	}
+	return false
+}
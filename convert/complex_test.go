@@ -0,0 +1,99 @@
+package convert_test
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+type signal struct {
+	Gain    complex64    `starlark:"gain"`
+	Samples []complex128 `starlark:"samples"`
+}
+
+// TestComplex verifies Go complex values convert to the complex type and
+// back.
+func TestComplex(t *testing.T) {
+	sig := &signal{Gain: 2, Samples: []complex128{1 + 2i, 3 - 4i}}
+	scale := func(c complex128, k complex64) complex128 {
+		return c * complex128(k)
+	}
+	globals, err := convert.MakeStringDictWithTag(map[string]interface{}{
+		"sig":   sig,
+		"scale": scale,
+		"z":     complex64(1i),
+	}, "starlark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := execWithThread(&starlark.Thread{}, `
+a, b = sig.samples[0], sig.samples[1]
+sum = a + b
+prod = a * b
+quot = b / 2
+mixed = 1 - a * 2.5
+power = z * z
+neg = -a
+parts = (b.real, b.imag, b.abs(), a.conj())
+same = a == sig.samples[0] and a != b
+cross = (power == -1, power.real == -1)
+scaled = scale(a, 2)
+typ = type(a)
+sig.gain = 0.5
+sig.samples[1] = 7
+hashed = {a: 1}[a]
+`, globals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"sum":    "(4-2j)",
+		"prod":   "(11+2j)",
+		"quot":   "(1.5-2j)",
+		"mixed":  "(-1.5-5j)",
+		"power":  "(-1+0j)",
+		"neg":    "(-1-2j)",
+		"parts":  "(3.0, -4.0, 5.0, (1-2j))",
+		"same":   "True",
+		"cross":  "(False, True)",
+		"scaled": "(2+4j)",
+		"typ":    `"complex"`,
+		"hashed": "1",
+	} {
+		if got := res[name].String(); got != want {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+	if sig.Gain != 0.5 || sig.Samples[1] != 7 {
+		t.Errorf("sig = %+v", sig)
+	}
+	if got := convert.FromValue(res["sum"]); got != complex128(4-2i) {
+		t.Errorf("FromValue(sum) = %v (%T)", got, got)
+	}
+
+	for script, want := range map[string]string{
+		`a < a`:                             "not ordered",
+		`a / 0`:                             "complex division by zero",
+		`sig.gain = sig.samples[0] * 1e300`: "out of range for type complex64",
+	} {
+		_, err := execWithThread(&starlark.Thread{}, "a = sig.samples[0]\n"+script, globals)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s = %v, want error containing %q", script, err, want)
+		}
+	}
+
+	var out signal
+	v := evalValue(t, `{"gain": 3, "samples": [1.5, 2]}`)
+	if err := convert.Decode(v, &out, convert.DecodeTag("starlark")); err != nil {
+		t.Fatal(err)
+	}
+	if out.Gain != 3 || len(out.Samples) != 2 || out.Samples[0] != 1.5 {
+		t.Errorf("Decode() = %+v", out)
+	}
+	if err := convert.Decode(starlark.Float(math.MaxFloat64), &out.Gain); err == nil {
+		t.Error("Decode() of an overflowing complex64 succeeded")
+	}
+}
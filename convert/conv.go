@@ -34,19 +34,21 @@ func (e *PanicError) Error() string {
 // It supports structs, maps, slices, and functions that use the aforementioned.
 // Any starlark.Value is passed through as-is.
 func ToValue(v interface{}) (starlark.Value, error) {
-	if val, ok := v.(starlark.Value); ok {
-		return passThroughOrNone(val), nil
-	}
-	return toValue(reflect.ValueOf(v), emptyStr)
+	return convertValue(v, emptyStr, nil)
 }
 
 // ToValueWithTag attempts to convert the given value to a starlark.Value.
 // It works like ToValue, but also accepts a tag name to use for all nested struct fields.
 func ToValueWithTag(v interface{}, tagName string) (starlark.Value, error) {
+	return convertValue(v, tagName, nil)
+}
+
+// convertValue converts v as ToValueWithTag does, in the scope sc.
+func convertValue(v interface{}, tagName string, sc *scope) (starlark.Value, error) {
 	if val, ok := v.(starlark.Value); ok {
 		return passThroughOrNone(val), nil
 	}
-	return toValue(reflect.ValueOf(v), tagName)
+	return toValue(reflect.ValueOf(v), tagName, sc)
 }
 
 // passThroughOrNone returns v unchanged, except a typed-nil pointer that
@@ -69,7 +71,7 @@ func hasMethods(val reflect.Value) bool {
 	return false
 }
 
-func toValue(val reflect.Value, tagName string) (result starlark.Value, err error) {
+func toValue(val reflect.Value, tagName string, sc *scope) (result starlark.Value, err error) {
 	defer func() {
 		if r := recover(); r != nil {
 			err = &PanicError{Value: r, Stack: debug.Stack()}
@@ -77,6 +79,9 @@ func toValue(val reflect.Value, tagName string) (result starlark.Value, err erro
 	}()
 
 	if val.IsValid() {
+		if sv, ok, err := sc.toRegistered(val); ok {
+			return sv, err
+		}
 		if sv, ok := val.Interface().(starlark.Value); ok {
 			// let Starlark values pass through, no conversion needed (a
 			// typed-nil pointer satisfying the interface becomes None)
@@ -88,9 +93,12 @@ func toValue(val reflect.Value, tagName string) (result starlark.Value, err erro
 		if val.Type() == durationType {
 			return startime.Duration(val.Interface().(time.Duration)), nil
 		}
+		if b, ok := markedStarFn("fn", val, tagName, sc); ok {
+			return b, nil
+		}
 		if hasMethods(val) {
 			// this handles all basic types with methods (numbers, strings, booleans)
-			ifc, ok := makeGoInterface(val)
+			ifc, ok := makeGoInterface(val, sc)
 			if ok {
 				return ifc, nil
 			}
@@ -108,7 +116,7 @@ func toValue(val reflect.Value, tagName string) (result starlark.Value, err erro
 			switch kind {
 			case reflect.Bool,
 				reflect.String,
-				reflect.Float32, reflect.Float64,
+				reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128,
 				reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
 				reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
 				reflect.Slice, reflect.Array, reflect.Map, reflect.Func:
@@ -117,7 +125,7 @@ func toValue(val reflect.Value, tagName string) (result starlark.Value, err erro
 		} else {
 			// If the pointer is nil and points to a struct, make a GoInterface for it
 			if val.Type().Elem().Kind() == reflect.Struct {
-				return &GoInterface{v: val}, nil
+				return &GoInterface{v: val, sc: sc}, nil
 			}
 		}
 	}
@@ -131,20 +139,27 @@ func toValue(val reflect.Value, tagName string) (result starlark.Value, err erro
 		return starlark.MakeUint64(val.Uint()), nil
 	case reflect.Float32, reflect.Float64:
 		return starlark.Float(val.Float()), nil
+	case reflect.Complex64, reflect.Complex128:
+		return Complex(val.Complex()), nil
 	case reflect.Func:
-		return makeStarFn("fn", val, tagName), nil
+		return makeStarFn("fn", val, tagName, sc, hostFn), nil
 	case reflect.Map:
-		if err := checkCollectionElemTypesCached(val.Type()); err != nil {
+		if err := sc.checkElemTypes(val.Type()); err != nil {
 			return nil, err
 		}
-		return &GoMap{v: val, tag: tagName}, nil
+		return &GoMap{v: val, tag: tagName, sc: sc}, nil
 	case reflect.String:
 		return starlark.String(val.String()), nil
 	case reflect.Slice, reflect.Array:
-		if err := checkCollectionElemTypesCached(val.Type()); err != nil {
+		if err := sc.checkElemTypes(val.Type()); err != nil {
 			return nil, err
 		}
-		return &GoSlice{v: arrayToSlice(val), tag: tagName}, nil
+		return &GoSlice{v: arrayToSlice(val), tag: tagName, sc: sc}, nil
+	case reflect.Chan:
+		if err := sc.checkElemTypes(val.Type()); err != nil {
+			return nil, err
+		}
+		return &GoChan{v: val, tag: tagName, sc: sc}, nil
 	case reflect.Struct:
 		// a *time.Time reaches here still as a pointer (the deref switch
 		// above skips struct pointers, to preserve *struct -> *GoStruct);
@@ -157,7 +172,7 @@ func toValue(val reflect.Value, tagName string) (result starlark.Value, err erro
 		case reflect.TypeOf(time.Time{}):
 			return startime.Time(val.Interface().(time.Time)), nil
 		}
-		return &GoStruct{v: val, tag: tagName}, nil
+		return &GoStruct{v: val, tag: tagName, sc: sc}, nil
 	case reflect.Interface:
 		// unwrap empty interfaces to their dynamic value: JSON-shaped data
 		// (map[string]interface{}, []interface{}) was unusable otherwise,
@@ -173,18 +188,18 @@ func toValue(val reflect.Value, tagName string) (result starlark.Value, err erro
 			if val.IsNil() {
 				return starlark.None, nil
 			}
-			if uv, err := toValue(val.Elem(), tagName); err == nil {
+			if uv, err := toValue(val.Elem(), tagName, sc); err == nil {
 				return uv, nil
 			}
-			// the dynamic value has no Starlark form (e.g. a chan, or a
+			// the dynamic value has no Starlark form (e.g. a uintptr, or a
 			// collection with an unsupported element type) — fall back to
 			// the opaque wrapper instead of erroring: the static pre-check
 			// cannot see through interface{}, and this error would
 			// otherwise surface inside methods that cannot return errors
 			// (Items, Index, iterators) and escape as a panic
-			return &GoInterface{v: val, tag: tagName}, nil
+			return &GoInterface{v: val, tag: tagName, sc: sc}, nil
 		}
-		return &GoInterface{v: val, tag: tagName}, nil
+		return &GoInterface{v: val, tag: tagName, sc: sc}, nil
 	case reflect.Invalid:
 		return starlark.None, nil
 	}
@@ -204,7 +219,18 @@ var elemTypeCheckCache = newBoundedTypeCache(elemTypeCacheCap) // reflect.Type -
 // checkCollectionElemTypesCached is the cached front of
 // checkCollectionElemTypes; use this on conversion hot paths.
 func checkCollectionElemTypesCached(t reflect.Type) error {
-	return elemTypeCheckCache.loadOrStore(t, checkCollectionElemTypes(t, nil))
+	return elemTypeCheckCache.loadOrStore(t, checkCollectionElemTypes(t, nil, nil))
+}
+
+// checkElemTypes is checkCollectionElemTypesCached in the scope sc: the
+// types registered in its registry are accepted too. Only the check
+// against the global registry is cached.
+func (sc *scope) checkElemTypes(t reflect.Type) error {
+	err := checkCollectionElemTypesCached(t)
+	if err != nil && sc != nil {
+		err = checkCollectionElemTypes(t, nil, sc)
+	}
+	return err
 }
 
 // checkCollectionElemTypes verifies that the key and element types of a map,
@@ -215,8 +241,9 @@ func checkCollectionElemTypesCached(t reflect.Type) error {
 // the unsupported type. Struct types are not descended into: their fields
 // are reached through GoStruct.Attr, which reports a regular error. The
 // visited set guards against recursive Go types (e.g. type M map[string]M);
-// pass nil to start.
-func checkCollectionElemTypes(t reflect.Type, visited map[reflect.Type]bool) error {
+// pass nil to start. Types registered in sc (see scope.registeredType) are
+// accepted as they are.
+func checkCollectionElemTypes(t reflect.Type, visited map[reflect.Type]bool, sc *scope) error {
 	if visited[t] {
 		return nil
 	}
@@ -224,14 +251,19 @@ func checkCollectionElemTypes(t reflect.Type, visited map[reflect.Type]bool) err
 		visited = make(map[reflect.Type]bool)
 	}
 	visited[t] = true
+	if _, ok := sc.registeredType(t); ok {
+		return nil
+	}
 	switch t.Kind() {
-	case reflect.Chan, reflect.Complex64, reflect.Complex128, reflect.UnsafePointer, reflect.Uintptr:
+	case reflect.UnsafePointer, reflect.Uintptr:
 		return fmt.Errorf("type %s is not a supported starlark type", t)
+	case reflect.Chan:
+		return checkCollectionElemTypes(t.Elem(), visited, sc)
 	case reflect.Map:
-		if err := checkCollectionElemTypes(t.Key(), visited); err != nil {
+		if err := checkCollectionElemTypes(t.Key(), visited, sc); err != nil {
 			return err
 		}
-		return checkCollectionElemTypes(t.Elem(), visited)
+		return checkCollectionElemTypes(t.Elem(), visited, sc)
 	case reflect.Ptr:
 		// a *func element is unsupported: a nil one errors in toValue, and
 		// GoSlice.Index / iterators cannot return that error (they would
@@ -239,9 +271,9 @@ func checkCollectionElemTypes(t reflect.Type, visited map[reflect.Type]bool) err
 		if t.Elem().Kind() == reflect.Func {
 			return fmt.Errorf("type %s is not a supported starlark type", t)
 		}
-		return checkCollectionElemTypes(t.Elem(), visited)
+		return checkCollectionElemTypes(t.Elem(), visited, sc)
 	case reflect.Slice, reflect.Array:
-		return checkCollectionElemTypes(t.Elem(), visited)
+		return checkCollectionElemTypes(t.Elem(), visited, sc)
 	}
 	return nil
 }
@@ -279,6 +311,8 @@ func fromValue(v starlark.Value, visited map[uintptr]bool) interface{} {
 		return v.BigInt()
 	case starlark.Float:
 		return float64(v)
+	case Complex:
+		return complex128(v)
 	case starlark.String:
 		return string(v)
 	case starlark.Bytes:
@@ -305,6 +339,8 @@ func fromValue(v starlark.Value, visited map[uintptr]bool) interface{} {
 		return v.v.Interface()
 	case *GoSlice:
 		return v.v.Interface()
+	case *GoChan:
+		return v.v.Interface()
 	default:
 		// dunno, hope it's a custom type that the receiver knows how to deal with.
 		// This can happen with custom-written go types that implement starlark.Value.
@@ -314,21 +350,31 @@ func fromValue(v starlark.Value, visited map[uintptr]bool) interface{} {
 }
 
 // MakeStringDict makes a StringDict from the given arg. The types supported are the same as ToValue.
-// It returns an empty dict for nil input.
+// Go functions are wrapped under the name of their key. It returns an empty dict for nil input.
 func MakeStringDict(m map[string]interface{}) (starlark.StringDict, error) {
-	return makeStringDictTag(m, emptyStr)
+	return makeStringDictTag(m, emptyStr, nil)
 }
 
 // MakeStringDictWithTag makes a StringDict from the given arg with custom tag. The types supported are the same as ToValueWithTag.
 // It returns an empty dict for nil input.
 func MakeStringDictWithTag(m map[string]interface{}, tagName string) (starlark.StringDict, error) {
-	return makeStringDictTag(m, tagName)
+	return makeStringDictTag(m, tagName, nil)
 }
 
-func makeStringDictTag(m map[string]interface{}, tagName string) (starlark.StringDict, error) {
+func makeStringDictTag(m map[string]interface{}, tagName string, sc *scope) (starlark.StringDict, error) {
 	dict := make(starlark.StringDict, len(m))
 	for k, v := range m {
-		val, err := ToValueWithTag(v, tagName)
+		// plain Go functions are named after their key, so errors and
+		// traces identify them
+		if rv := reflect.ValueOf(v); rv.Kind() == reflect.Func && !rv.IsNil() && rv.NumMethod() == 0 {
+			dict[k] = makeStarFn(k, rv, tagName, sc, hostFn)
+			continue
+		}
+		if b, ok := markedStarFn(k, reflect.ValueOf(v), tagName, sc); ok {
+			dict[k] = b
+			continue
+		}
+		val, err := convertValue(v, tagName, sc)
 		if err != nil {
 			return nil, err
 		}
@@ -419,16 +465,16 @@ func fromList(l *starlark.List, visited map[uintptr]bool) []interface{} {
 // MakeDict makes a Dict from the given map. The acceptable keys and values are the same as ToValue.
 // For nil input, it returns an empty Dict. It panics if the input is not a map.
 func MakeDict(v interface{}) (starlark.Value, error) {
-	return makeDictTag(reflect.ValueOf(v), emptyStr)
+	return makeDictTag(reflect.ValueOf(v), emptyStr, nil)
 }
 
 // MakeDictWithTag makes a Dict from the given map with custom tag. The acceptable keys and values are the same as ToValueWithTag.
 // For nil input, it returns an empty Dict. It panics if the input is not a map.
 func MakeDictWithTag(v interface{}, tagName string) (starlark.Value, error) {
-	return makeDictTag(reflect.ValueOf(v), tagName)
+	return makeDictTag(reflect.ValueOf(v), tagName, nil)
 }
 
-func makeDictTag(val reflect.Value, tagName string) (starlark.Value, error) {
+func makeDictTag(val reflect.Value, tagName string, sc *scope) (starlark.Value, error) {
 	dict := starlark.NewDict(1)
 	// check if the value is not nil and is a map
 	if valid := val.IsValid(); valid && val.Kind() != reflect.Map {
@@ -439,11 +485,11 @@ func makeDictTag(val reflect.Value, tagName string) (starlark.Value, error) {
 		// deterministic key order: Starlark dicts preserve insertion order,
 		// so the random order of MapKeys would be script-visible
 		for _, k := range sortedMapKeys(val) {
-			vk, err := toValue(k, tagName)
+			vk, err := toValue(k, tagName, sc)
 			if err != nil {
 				return nil, err
 			}
-			vv, err := toValue(val.MapIndex(k), tagName)
+			vv, err := toValue(val.MapIndex(k), tagName, sc)
 			if err != nil {
 				return nil, err
 			}
@@ -554,7 +600,7 @@ func comparableByValue(t reflect.Type) bool {
 // interface key types it routes through hashableGoValue, so tuples become
 // comparable arrays and values with no comparable Go form yield an error
 // instead of a runtime "hash of unhashable type" panic.
-func tryKeyConv(v starlark.Value, t reflect.Type) (reflect.Value, error) {
+func tryKeyConv(v starlark.Value, t reflect.Type, sc *scope) (reflect.Value, error) {
 	if t.Kind() == reflect.Interface {
 		g, err := hashableGoValue(v)
 		if err != nil {
@@ -571,7 +617,7 @@ func tryKeyConv(v starlark.Value, t reflect.Type) (reflect.Value, error) {
 	}
 	// Non-interface Go map key types are comparable by construction, so the
 	// regular conversion is already safe.
-	return tryConv(v, t)
+	return tryConv(v, t, sc)
 }
 
 // FromDict converts a starlark.Dict to a map[interface{}]interface{}.
@@ -742,47 +788,106 @@ func FromKwargs(kwargs []starlark.Tuple) ([]Kwarg, error) {
 }
 
 // MakeStarFn creates a wrapper around the given function that can be called from a starlark script. Argument support is the same as ToValue.
+// If the first parameter of the function is a *starlark.Thread or a context.Context, it is not taken from the script:
+// it receives the calling thread, or the context attached to that thread (see ThreadContext).
+// Calls made on a thread carrying a Tracer under TracerKey are reported to that Tracer.
 // If the last value the function returns is an error, it will cause an error to be returned from the starlark function.
 // If there are no other errors, the function will return None.
 // If there's exactly one other value, the function will return the starlark equivalent of that value.
 // If there is more than one return value, they'll be returned as a tuple.
+// On a deterministic thread (see IsDeterministic), the function fails unless gofn was marked with Deterministic.
+// A Starlark callable passed for a parameter of func type is adapted to that type, calling back into the script on the calling thread;
+// the function may call it on its own goroutine only, until it returns. An error raised by the callable is returned as the last
+// result of the func type if it is an error, and otherwise fails the call.
+// Scripts pass arguments by position only, unless gofn was bound with a parameter list by Func or Named.
 // MakeStarFn will panic if you pass it something other than a function, like nil or a non-function.
 func MakeStarFn(name string, gofn interface{}) *starlark.Builtin {
 	v := reflect.ValueOf(gofn)
+	if b, ok := markedStarFn(name, v, emptyStr, nil); ok {
+		return b
+	}
 	if v.Kind() != reflect.Func {
 		panic(errors.New("fn is not a function"))
 	}
-	return makeStarFn(name, v, emptyStr)
+	return makeStarFn(name, v, emptyStr, nil, hostFn)
 }
 
-func makeStarFn(name string, gofn reflect.Value, tagName string) *starlark.Builtin {
+// markedStarFn wraps val if it is a function marked with Deterministic or
+// bound by Func.
+func markedStarFn(name string, val reflect.Value, tagName string, sc *scope) (*starlark.Builtin, bool) {
+	if !val.IsValid() {
+		return nil, false
+	}
+	switch val.Type() {
+	case deterministicFuncType:
+		return makeStarFn(name, val.Interface().(deterministicFunc).fn, tagName, sc, markedFn), true
+	case bindingType:
+		b := val.Interface().(*Binding)
+		if b == nil {
+			return nil, false
+		}
+		if !b.named {
+			return makeStarFn(name, b.fn, tagName, sc, b.kind), true
+		}
+		return makeNamedStarFn(name, b, tagName, sc), true
+	}
+	return nil, false
+}
+
+func makeStarFn(name string, gofn reflect.Value, tagName string, sc *scope, kind fnKind) *starlark.Builtin {
 	if gofn.Type().IsVariadic() {
-		return makeVariadicStarFn(name, gofn, tagName)
+		return makeVariadicStarFn(name, gofn, tagName, sc, kind)
 	}
 	return starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (sv starlark.Value, ef error) {
+		// deferred before the recover below, so it sees recovered panics
+		if tr := threadTracer(thread); tr != nil {
+			start := time.Now()
+			defer func() { tr.report(thread, name, args, start, sv, ef) }()
+		}
 		defer func() {
 			if r := recover(); r != nil {
 				sv = starlark.None
-				ef = fmt.Errorf("panic in func %s: %v", name, r)
+				ef = panicError(name, r)
 			}
 		}()
 
+		if kind == hostFn && IsDeterministic(thread) {
+			return starlark.None, fmt.Errorf("%s: not allowed in a deterministic run (not marked with convert.Deterministic)", name)
+		}
 		if len(kwargs) > 0 {
-			return starlark.None, fmt.Errorf("%s: unexpected keyword argument %s (wrapped Go functions accept positional arguments only)", name, kwargs[0][0].String())
+			return starlark.None, fmt.Errorf("%s: unexpected keyword argument %s (wrapped Go functions accept positional arguments only, unless bound with convert.Func)", name, kwargs[0][0].String())
 		}
-		if len(args) != gofn.Type().NumIn() {
-			return starlark.None, fmt.Errorf("expected %d args but got %d", gofn.Type().NumIn(), len(args))
+		// a leading *starlark.Thread or context.Context parameter is
+		// supplied by the host, not the script
+		ft := gofn.Type()
+		skip := numHostArgs(ft)
+		if len(args) != ft.NumIn()-skip {
+			return starlark.None, fmt.Errorf("expected %d args but got %d", ft.NumIn()-skip, len(args))
 		}
 
 		// convert all the args
 		vals := FromTuple(args)
-		rvs := make([]reflect.Value, 0, len(vals))
+		rvs := appendHostArgs(make([]reflect.Value, 0, ft.NumIn()), ft, thread)
+		sc := callScope(thread, sc)
+		cbs := newCallbacks(thread, name, tagName, sc)
+		defer cbs.finish(&ef)
 		for i, v := range vals {
 			val := reflect.ValueOf(v)
-			argT := gofn.Type().In(i)
+			argT := ft.In(i + skip)
+			if cb, ok := cbs.adapt(args[i], argT); ok {
+				rvs = append(rvs, cb)
+				continue
+			}
+			if rv, ok, err := sc.fromRegistered(args[i], argT); ok {
+				if err != nil {
+					return starlark.None, fmt.Errorf("arg %d: %v", i, err)
+				}
+				rvs = append(rvs, rv)
+				continue
+			}
 
 			var err error
-			val, err = convertReflectValue(val, argT)
+			val, err = convertReflectValue(val, argT, sc)
 			if err != nil {
 				return starlark.None, fmt.Errorf("arg %d: %v", i, err)
 			}
@@ -791,38 +896,62 @@ func makeStarFn(name string, gofn reflect.Value, tagName string) *starlark.Built
 		}
 
 		out := gofn.Call(rvs)
-		return makeOut(out, tagName)
+		return makeOut(out, tagName, sc)
 	})
 }
 
-func makeVariadicStarFn(name string, gofn reflect.Value, tagName string) *starlark.Builtin {
+func makeVariadicStarFn(name string, gofn reflect.Value, tagName string, sc *scope, kind fnKind) *starlark.Builtin {
 	return starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (sv starlark.Value, ef error) {
+		// deferred before the recover below, so it sees recovered panics
+		if tr := threadTracer(thread); tr != nil {
+			start := time.Now()
+			defer func() { tr.report(thread, name, args, start, sv, ef) }()
+		}
 		defer func() {
 			if r := recover(); r != nil {
 				sv = starlark.None
-				ef = fmt.Errorf("panic in func %s: %v", name, r)
+				ef = panicError(name, r)
 			}
 		}()
 
+		if kind == hostFn && IsDeterministic(thread) {
+			return starlark.None, fmt.Errorf("%s: not allowed in a deterministic run (not marked with convert.Deterministic)", name)
+		}
 		if len(kwargs) > 0 {
-			return starlark.None, fmt.Errorf("%s: unexpected keyword argument %s (wrapped Go functions accept positional arguments only)", name, kwargs[0][0].String())
+			return starlark.None, fmt.Errorf("%s: unexpected keyword argument %s (wrapped Go functions accept positional arguments only, unless bound with convert.Func)", name, kwargs[0][0].String())
 		}
-		minArgs := gofn.Type().NumIn() - 1
+		ft := gofn.Type()
+		skip := numHostArgs(ft)
+		minArgs := ft.NumIn() - 1 - skip
 		if len(args) < minArgs {
 			return starlark.None, fmt.Errorf("expected at least %d args but got %d", minArgs, len(args))
 		}
 
 		// convert all the args
 		vals := FromTuple(args)
-		rvs := make([]reflect.Value, 0, len(args))
+		rvs := appendHostArgs(make([]reflect.Value, 0, skip+len(args)), ft, thread)
+		sc := callScope(thread, sc)
+		cbs := newCallbacks(thread, name, tagName, sc)
+		defer cbs.finish(&ef)
 
 		// grab all the non-variadics first
 		for i := 0; i < minArgs; i++ {
 			val := reflect.ValueOf(vals[i])
-			argT := gofn.Type().In(i)
+			argT := ft.In(i + skip)
+			if cb, ok := cbs.adapt(args[i], argT); ok {
+				rvs = append(rvs, cb)
+				continue
+			}
+			if rv, ok, err := sc.fromRegistered(args[i], argT); ok {
+				if err != nil {
+					return starlark.None, fmt.Errorf("arg %d: %v", i, err)
+				}
+				rvs = append(rvs, rv)
+				continue
+			}
 
 			var err error
-			val, err = convertReflectValue(val, argT)
+			val, err = convertReflectValue(val, argT, sc)
 			if err != nil {
 				return starlark.None, fmt.Errorf("arg %d: %v", i, err)
 			}
@@ -831,24 +960,35 @@ func makeVariadicStarFn(name string, gofn reflect.Value, tagName string) *starla
 		}
 		// last "in" type by definition must be a slice of something. We need to
 		// know what something, so we can convert things as needed.
-		vtype := gofn.Type().In(gofn.Type().NumIn() - 1).Elem()
+		vtype := ft.In(ft.NumIn() - 1).Elem()
 		// the rest of the args need to be batched into a slice for the variadic
 		for i := minArgs; i < len(vals); i++ {
 			val := reflect.ValueOf(vals[i])
+			if cb, ok := cbs.adapt(args[i], vtype); ok {
+				rvs = append(rvs, cb)
+				continue
+			}
+			if rv, ok, err := sc.fromRegistered(args[i], vtype); ok {
+				if err != nil {
+					return starlark.None, fmt.Errorf("arg %d: %v", i, err)
+				}
+				rvs = append(rvs, rv)
+				continue
+			}
 
 			var err error
-			val, err = convertReflectValue(val, vtype)
+			val, err = convertReflectValue(val, vtype, sc)
 			if err != nil {
 				return starlark.None, fmt.Errorf("arg %d: %v", i, err)
 			}
 			rvs = append(rvs, val)
 		}
 		out := gofn.Call(rvs)
-		return makeOut(out, tagName)
+		return makeOut(out, tagName, sc)
 	})
 }
 
-func makeOut(out []reflect.Value, tagName string) (starlark.Value, error) {
+func makeOut(out []reflect.Value, tagName string, sc *scope) (starlark.Value, error) {
 	if len(out) == 0 {
 		return starlark.None, nil
 	}
@@ -887,7 +1027,7 @@ func makeOut(out []reflect.Value, tagName string) (starlark.Value, error) {
 		return starlark.None, err
 	}
 	if len(out) == 1 {
-		v, err2 := toValue(out[0], tagName)
+		v, err2 := toValue(out[0], tagName, sc)
 		if err2 != nil {
 			return starlark.None, err2
 		}
@@ -896,7 +1036,7 @@ func makeOut(out []reflect.Value, tagName string) (starlark.Value, error) {
 	// tuple-up multiple values
 	res := make([]starlark.Value, 0, len(out))
 	for i := range out {
-		val, err3 := toValue(out[i], tagName)
+		val, err3 := toValue(out[i], tagName, sc)
 		if err3 != nil {
 			return starlark.None, err3
 		}
@@ -909,8 +1049,10 @@ func makeOut(out []reflect.Value, tagName string) (starlark.Value, error) {
 // go through checkedConvert, so values that ConvertibleTo would silently
 // corrupt (codepoint conversion, wrap-around, truncation) are errors. An
 // invalid val (a Starlark None) is accepted only for nullable types, the
-// same policy tryConv applies (it used to be silently zeroed here).
-func convertReflectValue(val reflect.Value, argT reflect.Type) (reflect.Value, error) {
+// same policy tryConv applies (it used to be silently zeroed here). The
+// elements of slices and maps are converted with the conversions
+// registered in sc for their type, if any.
+func convertReflectValue(val reflect.Value, argT reflect.Type, sc *scope) (reflect.Value, error) {
 	if !val.IsValid() {
 		switch argT.Kind() {
 		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func:
@@ -922,25 +1064,32 @@ func convertReflectValue(val reflect.Value, argT reflect.Type) (reflect.Value, e
 	if val.Type().AssignableTo(argT) {
 		return val, nil
 	}
-	if val.Type().ConvertibleTo(argT) {
+	if convertibleTo(val.Type(), argT) {
 		return checkedConvert(val, argT)
 	}
 	if val.Kind() == reflect.Slice && argT.Kind() == reflect.Slice {
-		return convertSlice(val, argT)
+		return convertSlice(val, argT, sc)
 	}
 	if val.Kind() == reflect.Map && argT.Kind() == reflect.Map {
-		return convertMap(val, argT)
+		return convertMap(val, argT, sc)
 	}
 	return reflect.Value{}, fmt.Errorf("expected type %v got %v", argT, val.Type())
 }
 
-func convertSlice(val reflect.Value, argT reflect.Type) (reflect.Value, error) {
+func convertSlice(val reflect.Value, argT reflect.Type, sc *scope) (reflect.Value, error) {
 	argElem := argT.Elem()
 	valLen := val.Len()
 	newSlice := reflect.MakeSlice(argT, valLen, valLen)
 
 	for i := 0; i < valLen; i++ {
 		elem := val.Index(i)
+		if rv, ok, err := sc.fromRegisteredElem(elem, argElem); ok {
+			if err != nil {
+				return reflect.Value{}, fmt.Errorf("slice element %d: %v", i, err)
+			}
+			newSlice.Index(i).Set(rv)
+			continue
+		}
 
 		// a None element arrives as a nil interface value; apply the same
 		// policy as scalar arguments before any elem.Elem() access (which
@@ -957,13 +1106,13 @@ func convertSlice(val reflect.Value, argT reflect.Type) (reflect.Value, error) {
 
 		if elem.Type().AssignableTo(argElem) {
 			newSlice.Index(i).Set(elem)
-		} else if elem.Type().ConvertibleTo(argElem) {
+		} else if convertibleTo(elem.Type(), argElem) {
 			cv, err := checkedConvert(elem, argElem)
 			if err != nil {
 				return reflect.Value{}, fmt.Errorf("slice element %d: %v", i, err)
 			}
 			newSlice.Index(i).Set(cv)
-		} else if (elem.Kind() == reflect.Interface || elem.Kind() == reflect.Ptr) && elem.Elem().Type().ConvertibleTo(argElem) {
+		} else if (elem.Kind() == reflect.Interface || elem.Kind() == reflect.Ptr) && convertibleTo(elem.Elem().Type(), argElem) {
 			// only unwrap interface/pointer elements; elem.Elem() panics on a
 			// concrete-kind element (e.g. an int8 from a wrapped []int8)
 			cv, err := checkedConvert(elem.Elem(), argElem)
@@ -979,19 +1128,19 @@ func convertSlice(val reflect.Value, argT reflect.Type) (reflect.Value, error) {
 	return newSlice, nil
 }
 
-func convertMap(val reflect.Value, argT reflect.Type) (reflect.Value, error) {
+func convertMap(val reflect.Value, argT reflect.Type, sc *scope) (reflect.Value, error) {
 	argKey := argT.Key()
 	argElem := argT.Elem()
 	newMap := reflect.MakeMapWithSize(argT, val.Len())
 
 	for _, key := range val.MapKeys() {
-		newKey, err := convertElemValue(key, argKey)
+		newKey, err := convertElemValue(key, argKey, sc)
 		if err != nil {
 			return reflect.Value{}, fmt.Errorf("map key conversion failed: %v", err)
 		}
 
 		valElem := val.MapIndex(key)
-		newElem, err := convertElemValue(valElem, argElem)
+		newElem, err := convertElemValue(valElem, argElem, sc)
 		if err != nil {
 			return reflect.Value{}, fmt.Errorf("map value conversion failed: %v", err)
 		}
@@ -1002,8 +1151,11 @@ func convertMap(val reflect.Value, argT reflect.Type) (reflect.Value, error) {
 	return newMap, nil
 }
 
-func convertElemValue(val reflect.Value, targetType reflect.Type) (reflect.Value, error) {
-	if val.Type().AssignableTo(targetType) || val.Type().ConvertibleTo(targetType) {
+func convertElemValue(val reflect.Value, targetType reflect.Type, sc *scope) (reflect.Value, error) {
+	if rv, ok, err := sc.fromRegisteredElem(val, targetType); ok {
+		return rv, err
+	}
+	if val.Type().AssignableTo(targetType) || convertibleTo(val.Type(), targetType) {
 		return checkedConvert(val, targetType)
 	} else if val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
 		if val.IsNil() {
@@ -1016,11 +1168,11 @@ func convertElemValue(val reflect.Value, targetType reflect.Type) (reflect.Value
 				return reflect.Value{}, fmt.Errorf("value of type None cannot be converted to non-nullable type %s", targetType)
 			}
 		}
-		if val.Elem().Type().ConvertibleTo(targetType) {
+		if convertibleTo(val.Elem().Type(), targetType) {
 			return checkedConvert(val.Elem(), targetType)
 		} else if val.Type().Kind() == reflect.Interface {
 			unwrapped := val.Elem()
-			if unwrapped.Type().ConvertibleTo(targetType) {
+			if convertibleTo(unwrapped.Type(), targetType) {
 				return checkedConvert(unwrapped, targetType)
 			} else if sv, ok := unwrapped.Interface().(starlark.Value); ok {
 				// reached when a host passes a custom starlark.Value inside a
@@ -1041,7 +1193,10 @@ func convertElemValue(val reflect.Value, targetType reflect.Type) (reflect.Value
 }
 
 // tryConv tries to convert starlark.Value v to Go t if v is not assignable to t.
-func tryConv(v starlark.Value, t reflect.Type) (reflect.Value, error) {
+func tryConv(v starlark.Value, t reflect.Type, sc *scope) (reflect.Value, error) {
+	if rv, ok, err := sc.fromRegistered(v, t); ok {
+		return rv, err
+	}
 	if v == starlark.None {
 		switch t.Kind() {
 		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func:
@@ -1065,11 +1220,17 @@ func tryConv(v starlark.Value, t reflect.Type) (reflect.Value, error) {
 //   - float -> integer conversion truncates (3.9 -> 3); whole floats
 //     convert fine.
 //
+// Integers and floats also convert to complex types, which Convert
+// refuses, and complex narrowing that overflows complex64 is refused.
+//
 // Values already assignable to t pass through untouched.
 func checkedConvert(val reflect.Value, t reflect.Type) (reflect.Value, error) {
 	if val.Type().AssignableTo(t) {
 		return val, nil
 	}
+	if k := t.Kind(); k == reflect.Complex64 || k == reflect.Complex128 {
+		return convertComplex(val, t)
+	}
 	if !val.Type().ConvertibleTo(t) {
 		return reflect.Value{}, fmt.Errorf("value of type %s cannot be converted to type %s", val.Type(), t)
 	}
@@ -31,7 +31,7 @@ func TestMakeTuple(t *testing.T) {
 		t.Errorf("unexpected error 2: %v", err)
 		return
 	}
-	if _, err = MakeTuple([]interface{}{make(chan int)}); err == nil {
+	if _, err = MakeTuple([]interface{}{uintptr(1)}); err == nil {
 		t.Errorf("expected error 3, got nil")
 		return
 	}
@@ -117,7 +117,7 @@ func TestMakeList(t *testing.T) {
 		t.Errorf("unexpected error 2: %v", err)
 		return
 	}
-	if _, err = MakeList([]interface{}{make(chan int)}); err == nil {
+	if _, err = MakeList([]interface{}{uintptr(1)}); err == nil {
 		t.Errorf("expected error 3, got nil")
 		return
 	}
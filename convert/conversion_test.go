@@ -590,13 +590,13 @@ t = type(m["inner"])`), globals, nil)
 // (unknowable at wrap time). Collections whose static key/element type is
 // unsupported keep failing the conversion up front.
 func TestStaticUnsupportedStillErrors(t *testing.T) {
-	if _, err := convert.MakeDict(map[string]complex128{"b": 3 + 4i}); err == nil {
+	if _, err := convert.MakeDict(map[string]uintptr{"b": 3}); err == nil {
 		t.Fatal("expected error for statically unsupported value type")
 	}
-	if _, err := convert.MakeDict(map[complex64]string{3i: "b"}); err == nil {
+	if _, err := convert.MakeDict(map[uintptr]string{3: "b"}); err == nil {
 		t.Fatal("expected error for statically unsupported key type")
 	}
-	if _, err := convert.ToValue(map[string]chan int{}); err == nil {
+	if _, err := convert.ToValue(map[string][]uintptr{}); err == nil {
 		t.Fatal("expected error from the static element pre-check")
 	}
 }
@@ -0,0 +1,458 @@
+package convert
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"time"
+
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+)
+
+// DecodeOption configures Decode.
+type DecodeOption func(*decoder)
+
+// DecodeLenient makes Decode ignore the keys and attributes that match no
+// field of a struct, and truncate floats with a fraction decoded into
+// integers (3.9 -> 3), where it fails by default. Integers out of range for
+// their target type fail in both modes.
+func DecodeLenient() DecodeOption {
+	return func(d *decoder) {
+		d.lenient = true
+	}
+}
+
+// DecodeTag sets the struct tag naming the fields Decode fills, instead of
+// DefaultPropertyTag.
+func DecodeTag(tagName string) DecodeOption {
+	return func(d *decoder) {
+		d.tagName = tagName
+	}
+}
+
+// DecodeTypes makes Decode consult the conversions registered in r before
+// the global ones (see TypeRegistry).
+func DecodeTypes(r *TypeRegistry) DecodeOption {
+	return func(d *decoder) {
+		if r != nil {
+			d.sc = r.scope()
+		}
+	}
+}
+
+// DecodeError reports a value Decode could not decode, and where it was.
+type DecodeError struct {
+	Path string // e.g. orders[3].items["sku"]; empty for the value itself
+	Err  error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error { return e.Err }
+
+// Decode stores the Starlark value v into the Go value out points to,
+// converting it to the type of out as a whole instead of to the loose types
+// of FromValue. It decodes:
+//
+//   - bools, strings, and numbers of any Go numeric type, complex ones
+//     included, refusing the values out of range for the target type;
+//   - lists, tuples and sets into slices and arrays, and bytes or strings
+//     into []byte;
+//   - dicts into maps, decoding keys and values, and dicts with string keys
+//     or values with attributes (such as structs) into structs, by field tag
+//     (see NewStruct) or field name, honoring the inline and required tag
+//     options (see CheckStructTags);
+//   - time values, durations and duration strings into time.Time and
+//     time.Duration;
+//   - strings and bytes into implementations of encoding.TextUnmarshaler;
+//   - None into pointers, slices, maps and interfaces as nil; other values
+//     into pointers by allocating the pointed-to value;
+//   - anything into interface{}, as by FromValue;
+//   - wrapped Go values into their own type or the types it is assignable to;
+//   - anything into the types given conversions with RegisterType, or in
+//     the registry of DecodeTypes, which take precedence over the above.
+//
+// It fails with a *DecodeError naming the path of the value at fault, and
+// for struct tag options it does not understand. By
+// default, keys matching no struct field and floats with a fraction
+// decoded into integers are errors; see DecodeLenient.
+func Decode(v starlark.Value, out interface{}, opts ...DecodeOption) error {
+	rv := reflect.ValueOf(out)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("decode needs a non-nil pointer, got %T", out)
+	}
+	d := &decoder{tagName: DefaultPropertyTag, onPath: make(map[starlark.Value]bool)}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(d)
+		}
+	}
+	return d.decode("", v, rv.Elem())
+}
+
+type decoder struct {
+	lenient bool
+	tagName string
+	sc      *scope
+	onPath  map[starlark.Value]bool // containers being decoded, to stop cycles
+}
+
+var (
+	timeType            = reflect.TypeOf(time.Time{})
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	starlarkValueType   = reflect.TypeOf((*starlark.Value)(nil)).Elem()
+)
+
+// decode stores v, found at path, into the settable out.
+func (d *decoder) decode(path string, v starlark.Value, out reflect.Value) error {
+	fail := func(format string, args ...interface{}) error {
+		return &DecodeError{Path: path, Err: fmt.Errorf(format, args...)}
+	}
+	t := out.Type()
+
+	if rv, ok, err := d.sc.fromRegistered(v, t); ok {
+		if err != nil {
+			return &DecodeError{Path: path, Err: err}
+		}
+		out.Set(rv)
+		return nil
+	}
+	if v == starlark.None {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface:
+			out.Set(reflect.Zero(t))
+			return nil
+		}
+		return fail("cannot decode None into %s", t)
+	}
+	if t.Implements(starlarkValueType) && reflect.TypeOf(v).AssignableTo(t) {
+		out.Set(reflect.ValueOf(v))
+		return nil
+	}
+	if w, ok := v.(interface{ Value() reflect.Value }); ok {
+		if gv := w.Value(); gv.IsValid() && gv.Type().AssignableTo(t) {
+			out.Set(gv)
+			return nil
+		}
+	}
+	switch v := v.(type) {
+	case *starlark.List, *starlark.Dict, *starlark.Set:
+		if t.Kind() == reflect.Interface {
+			// decoded by FromValue, which handles cycles
+			break
+		}
+		if d.onPath[v] {
+			return fail("cannot decode a cyclic %s", v.Type())
+		}
+		d.onPath[v] = true
+		defer delete(d.onPath, v)
+	}
+
+	switch t {
+	case timeType:
+		if tv, ok := v.(startime.Time); ok {
+			out.Set(reflect.ValueOf(time.Time(tv)))
+			return nil
+		}
+	case durationType:
+		switch dv := v.(type) {
+		case startime.Duration:
+			out.SetInt(int64(dv))
+			return nil
+		case starlark.String:
+			dur, err := time.ParseDuration(string(dv))
+			if err != nil {
+				return fail("%v", err)
+			}
+			out.SetInt(int64(dur))
+			return nil
+		}
+		return fail("cannot decode %s into %s", v.Type(), t)
+	}
+	if t.Kind() != reflect.Interface && reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		var text []byte
+		switch sv := v.(type) {
+		case starlark.String:
+			text = []byte(sv)
+		case starlark.Bytes:
+			text = []byte(sv)
+		}
+		if text != nil {
+			if err := out.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(text); err != nil {
+				return fail("%v", err)
+			}
+			return nil
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		g := FromValue(v)
+		if g == nil {
+			out.Set(reflect.Zero(t))
+			return nil
+		}
+		if !reflect.TypeOf(g).Implements(t) {
+			return fail("cannot decode %s into %s", v.Type(), t)
+		}
+		out.Set(reflect.ValueOf(g))
+		return nil
+	case reflect.Ptr:
+		p := reflect.New(t.Elem())
+		if err := d.decode(path, v, p.Elem()); err != nil {
+			return err
+		}
+		out.Set(p)
+		return nil
+	case reflect.Bool:
+		b, ok := v.(starlark.Bool)
+		if !ok {
+			return fail("cannot decode %s into %s", v.Type(), t)
+		}
+		out.SetBool(bool(b))
+		return nil
+	case reflect.String:
+		s, ok := v.(starlark.String)
+		if !ok {
+			return fail("cannot decode %s into %s", v.Type(), t)
+		}
+		out.SetString(string(s))
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return d.decodeInt(v, out, fail)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch n := v.(type) {
+		case starlark.Float:
+			f = float64(n)
+		case starlark.Int:
+			f = float64(n.Float())
+		default:
+			return fail("cannot decode %s into %s", v.Type(), t)
+		}
+		if out.OverflowFloat(f) {
+			return fail("value %v out of range for %s", f, t)
+		}
+		out.SetFloat(f)
+		return nil
+	case reflect.Complex64, reflect.Complex128:
+		c, ok := asComplex(v)
+		if !ok {
+			return fail("cannot decode %s into %s", v.Type(), t)
+		}
+		if out.OverflowComplex(c) {
+			return fail("value %v out of range for %s", c, t)
+		}
+		out.SetComplex(c)
+		return nil
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			switch b := v.(type) {
+			case starlark.Bytes:
+				out.SetBytes([]byte(b))
+				return nil
+			case starlark.String:
+				out.SetBytes([]byte(b))
+				return nil
+			}
+		}
+		elems, ok := sequence(v)
+		if !ok {
+			return fail("cannot decode %s into %s", v.Type(), t)
+		}
+		s := reflect.MakeSlice(t, len(elems), len(elems))
+		for i, e := range elems {
+			if err := d.decode(fmt.Sprintf("%s[%d]", path, i), e, s.Index(i)); err != nil {
+				return err
+			}
+		}
+		out.Set(s)
+		return nil
+	case reflect.Array:
+		elems, ok := sequence(v)
+		if !ok {
+			return fail("cannot decode %s into %s", v.Type(), t)
+		}
+		if len(elems) != t.Len() {
+			return fail("got %d elements, want %d for %s", len(elems), t.Len(), t)
+		}
+		a := reflect.New(t).Elem()
+		for i, e := range elems {
+			if err := d.decode(fmt.Sprintf("%s[%d]", path, i), e, a.Index(i)); err != nil {
+				return err
+			}
+		}
+		out.Set(a)
+		return nil
+	case reflect.Map:
+		items, ok := mapping(v)
+		if !ok {
+			return fail("cannot decode %s into %s", v.Type(), t)
+		}
+		m := reflect.MakeMapWithSize(t, len(items))
+		for _, kv := range items {
+			at := fmt.Sprintf("%s[%s]", path, kv[0].String())
+			k := reflect.New(t.Key()).Elem()
+			if err := d.decode(at, kv[0], k); err != nil {
+				return err
+			}
+			e := reflect.New(t.Elem()).Elem()
+			if err := d.decode(at, kv[1], e); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, e)
+		}
+		out.Set(m)
+		return nil
+	case reflect.Struct:
+		return d.decodeStruct(path, v, out, fail)
+	}
+	return fail("cannot decode %s into %s", v.Type(), t)
+}
+
+// decodeInt stores the integer v into out, of an integer kind.
+func (d *decoder) decodeInt(v starlark.Value, out reflect.Value, fail func(string, ...interface{}) error) error {
+	t := out.Type()
+	var n starlark.Int
+	switch x := v.(type) {
+	case starlark.Int:
+		n = x
+	case starlark.Float:
+		f := float64(x)
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return fail("value %v out of range for %s", f, t)
+		}
+		if f != math.Trunc(f) && !d.lenient {
+			return fail("value %v is not an integer", f)
+		}
+		i, _ := new(big.Float).SetFloat64(math.Trunc(f)).Int(nil)
+		n = starlark.MakeBigInt(i)
+	default:
+		return fail("cannot decode %s into %s", v.Type(), t)
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := n.Int64()
+		if !ok || out.OverflowInt(i) {
+			return fail("value %s out of range for %s", n, t)
+		}
+		out.SetInt(i)
+	default:
+		u, ok := n.Uint64()
+		if !ok || out.OverflowUint(u) {
+			return fail("value %s out of range for %s", n, t)
+		}
+		out.SetUint(u)
+	}
+	return nil
+}
+
+// decodeStruct stores v, a mapping with string keys or a value with
+// attributes, into the struct out.
+func (d *decoder) decodeStruct(path string, v starlark.Value, out reflect.Value, fail func(string, ...interface{}) error) error {
+	t := out.Type()
+	list := structFields(t, d.tagName)
+	fields := make(map[string]structField, len(list))
+	for _, f := range list {
+		fields[f.name] = f
+	}
+	seen := make(map[string]bool, len(list))
+	join := func(name string) string {
+		if path == "" {
+			return name
+		}
+		return path + "." + name
+	}
+	// decoded into a copy, so out is left alone on failure
+	s := reflect.New(t).Elem()
+	set := func(name string, fv starlark.Value, method bool) error {
+		f, ok := fields[name]
+		if !ok {
+			if d.lenient || method {
+				return nil
+			}
+			return &DecodeError{Path: join(name), Err: errors.New("no such field in " + t.String())}
+		}
+		seen[name] = true
+		field, err := fieldByIndexAlloc(s, f.index)
+		if err != nil {
+			return &DecodeError{Path: join(name), Err: err}
+		}
+		return d.decode(join(name), fv, field)
+	}
+
+	if items, ok := mapping(v); ok {
+		for _, kv := range items {
+			k, ok := kv[0].(starlark.String)
+			if !ok {
+				return fail("cannot decode %s key %s into a field of %s", v.Type(), kv[0].Type(), t)
+			}
+			if err := set(string(k), kv[1], false); err != nil {
+				return err
+			}
+		}
+	} else if ha, ok := v.(starlark.HasAttrs); ok {
+		for _, name := range ha.AttrNames() {
+			av, err := ha.Attr(name)
+			if err != nil || av == nil {
+				continue
+			}
+			_, method := av.(starlark.Callable)
+			if err := set(name, av, method); err != nil {
+				return err
+			}
+		}
+	} else {
+		return fail("cannot decode %s into %s", v.Type(), t)
+	}
+	for _, f := range list {
+		if f.required && !seen[f.name] {
+			return &DecodeError{Path: join(f.name), Err: errors.New("missing required field")}
+		}
+	}
+	out.Set(s)
+	return nil
+}
+
+// sequence returns the elements of v if it is a list, tuple, set or other
+// indexable value.
+func sequence(v starlark.Value) ([]starlark.Value, bool) {
+	switch x := v.(type) {
+	case starlark.String, starlark.Bytes:
+		return nil, false
+	case starlark.Indexable:
+		elems := make([]starlark.Value, x.Len())
+		for i := range elems {
+			elems[i] = x.Index(i)
+		}
+		return elems, true
+	case *starlark.Set:
+		elems := make([]starlark.Value, 0, x.Len())
+		iter := x.Iterate()
+		defer iter.Done()
+		var e starlark.Value
+		for iter.Next(&e) {
+			elems = append(elems, e)
+		}
+		return elems, true
+	}
+	return nil, false
+}
+
+// mapping returns the items of v if it is a dict or other mapping.
+func mapping(v starlark.Value) ([]starlark.Tuple, bool) {
+	if m, ok := v.(starlark.IterableMapping); ok {
+		return m.Items(), true
+	}
+	return nil, false
+}
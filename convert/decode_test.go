@@ -0,0 +1,130 @@
+package convert_test
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+type lineItem struct {
+	Qty   uint8   `starlark:"qty"`
+	Price float64 `starlark:"price"`
+}
+
+type purchase struct {
+	ID      int                 `starlark:"id"`
+	Items   map[string]lineItem `starlark:"items"`
+	Tags    []string            `starlark:"tags"`
+	Placed  time.Time           `starlark:"placed"`
+	Timeout time.Duration       `starlark:"timeout"`
+	Addr    net.IP              `starlark:"addr"`
+	Note    *string             `starlark:"note"`
+	Pair    [2]int              `starlark:"pair"`
+	Extra   interface{}         `starlark:"extra"`
+	Raw     []byte              `starlark:"raw"`
+}
+
+func evalValue(t *testing.T, expr string) starlark.Value {
+	t.Helper()
+	res, err := execWithThread(&starlark.Thread{}, "v = "+expr, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res["v"]
+}
+
+// TestDecode verifies Decode fills typed Go values from Starlark values.
+func TestDecode(t *testing.T) {
+	v := evalValue(t, `[{
+    "id": 7,
+    "items": {"sku": {"qty": 3, "price": 9.5}},
+    "tags": ("a", "b"),
+    "placed": "2024-05-01T10:00:00Z",
+    "timeout": "1m30s",
+    "addr": "10.0.0.1",
+    "note": "fragile",
+    "pair": [1, 2],
+    "extra": {"k": [1]},
+    "raw": b"xy",
+}, {"id": 8, "note": None}]`)
+	var got []purchase
+	if err := convert.Decode(v, &got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("got %d purchases", len(got))
+	}
+	p := got[0]
+	if p.ID != 7 || p.Items["sku"] != (lineItem{3, 9.5}) || strings.Join(p.Tags, ",") != "a,b" ||
+		!p.Placed.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) || p.Timeout != 90*time.Second ||
+		p.Addr.String() != "10.0.0.1" || p.Note == nil || *p.Note != "fragile" || p.Pair != [2]int{1, 2} ||
+		string(p.Raw) != "xy" {
+		t.Fatalf("decoded %+v", p)
+	}
+	if m, ok := p.Extra.(map[interface{}]interface{}); !ok || len(m) != 1 {
+		t.Fatalf("extra = %#v", p.Extra)
+	}
+	if got[1].ID != 8 || got[1].Note != nil {
+		t.Fatalf("decoded %+v", got[1])
+	}
+
+	// struct values decode by attribute, wrapped Go values by assignment
+	var li lineItem
+	wrapped, _ := convert.ToValue(&lineItem{Qty: 1, Price: 2})
+	if err := convert.Decode(wrapped, &li); err != nil || li != (lineItem{1, 2}) {
+		t.Fatalf("Decode(wrapped) = %+v, %v", li, err)
+	}
+	var n uint16
+	if err := convert.Decode(starlark.MakeInt(500), &n); err != nil || n != 500 {
+		t.Fatalf("Decode(500) = %d, %v", n, err)
+	}
+	if err := convert.Decode(starlark.MakeInt(1), li); err == nil {
+		t.Fatal("expected an error for a non-pointer")
+	}
+}
+
+// TestDecodeErrors verifies the paths and the strict and lenient modes of
+// Decode.
+func TestDecodeErrors(t *testing.T) {
+	for expr, msg := range map[string]string{
+		`[{}, {}, {}, {"items": {"sku": {"qty": 300}}}]`: `[3].items["sku"].qty: value 300 out of range for uint8`,
+		`[{"id": "x"}]`:                    `[0].id: cannot decode string into int`,
+		`[{"idd": 1}]`:                     `[0].idd: no such field in convert_test.purchase`,
+		`[{"items": {"a": {"qty": 1.5}}}]`: `[0].items["a"].qty: value 1.5 is not an integer`,
+		`[{"pair": [1]}]`:                  `[0].pair: got 1 elements, want 2 for [2]int`,
+		`[{"timeout": "soon"}]`:            `[0].timeout: time: invalid duration "soon"`,
+		`[{"addr": "nope"}]`:               `[0].addr: invalid IP address: nope`,
+		`[{"items": {"a": {"qty": -1}}}]`:  `[0].items["a"].qty: value -1 out of range for uint8`,
+		`[{1: 2}]`:                         `[0]: cannot decode dict key int into a field of convert_test.purchase`,
+	} {
+		var got []purchase
+		err := convert.Decode(evalValue(t, expr), &got)
+		var de *convert.DecodeError
+		if err == nil || err.Error() != msg || !errors.As(err, &de) {
+			t.Errorf("%s: expected %q, got %v", expr, msg, err)
+		}
+	}
+
+	var got []purchase
+	v := evalValue(t, `[{"id": 1.9, "unknown": True}]`)
+	if err := convert.Decode(v, &got, convert.DecodeLenient()); err != nil || got[0].ID != 1 {
+		t.Fatalf("lenient decode = %+v, %v", got, err)
+	}
+
+	cyclic := starlark.NewList(nil)
+	_ = cyclic.Append(cyclic)
+	var nested []interface{}
+	if err := convert.Decode(cyclic, &nested); err != nil {
+		t.Fatalf("interface elements decode as by FromValue: %v", err)
+	}
+	type loop []loop
+	var l loop
+	if err := convert.Decode(cyclic, &l); err == nil || !strings.Contains(err.Error(), "cyclic") {
+		t.Fatalf("expected a cycle error, got %v", err)
+	}
+}
@@ -0,0 +1,79 @@
+package convert
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// DeterministicKey is the thread-local key that marks a Starlark run as
+// deterministic (see starlight.WithDeterministic). On such threads, wrapped
+// Go functions not marked with Deterministic refuse to run.
+const DeterministicKey = "starlight.deterministic"
+
+// RandKey is the thread-local key under which the host stores the
+// *rand.Rand returned by ThreadRand.
+const RandKey = "starlight.rand"
+
+// fnKind tells the wrapper of a Go function whether it may run on a
+// deterministic thread.
+type fnKind int
+
+const (
+	hostFn   fnKind = iota // a host function, rejected on deterministic threads
+	markedFn               // a host function marked with Deterministic
+	methodFn               // a method of a wrapped Go value, bound to its receiver
+)
+
+// deterministicFunc is a Go function marked with Deterministic.
+type deterministicFunc struct {
+	fn reflect.Value
+}
+
+var deterministicFuncType = reflect.TypeOf(deterministicFunc{})
+
+// Deterministic marks the Go function fn as safe to call in deterministic
+// runs: its result depends only on its arguments, or on state the run
+// controls such as ThreadRand. Pass the returned value wherever fn would go
+// (ToValue, MakeStringDict, MakeStarFn). Functions not marked this way fail
+// when called on a deterministic thread; methods of wrapped Go values are
+// not checked, since they are bound to a receiver the host chose to expose.
+// Deterministic panics if fn is not a function or a Binding.
+func Deterministic(fn interface{}) interface{} {
+	if b, ok := fn.(*Binding); ok {
+		nb := *b
+		nb.kind = markedFn
+		return &nb
+	}
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		panic(errors.New("fn is not a function"))
+	}
+	return deterministicFunc{fn: v}
+}
+
+// IsDeterministic reports whether thread belongs to a deterministic run.
+func IsDeterministic(thread *starlark.Thread) bool {
+	if thread == nil {
+		return false
+	}
+	b, _ := thread.Local(DeterministicKey).(bool)
+	return b
+}
+
+// ThreadRand returns the random source stored on thread under RandKey. In
+// deterministic runs it is seeded from the run options, so host helpers
+// drawing from it replay the same sequence; without one, it returns a new
+// source seeded from the current time. The source is not safe for
+// concurrent use, like the thread itself.
+func ThreadRand(thread *starlark.Thread) *rand.Rand {
+	if thread != nil {
+		if r, ok := thread.Local(RandKey).(*rand.Rand); ok && r != nil {
+			return r
+		}
+	}
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
@@ -0,0 +1,72 @@
+package convert_test
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+type counter struct{ n int }
+
+func (c *counter) Next() int { c.n++; return c.n }
+
+// TestDeterministic verifies deterministic threads reject unmarked host
+// functions but allow marked ones and methods of wrapped values.
+func TestDeterministic(t *testing.T) {
+	envs, err := convert.MakeStringDict(map[string]interface{}{
+		"double": convert.Deterministic(func(n int) int { return n * 2 }),
+		"roll":   func(th *starlark.Thread) int { return convert.ThreadRand(th).Intn(6) },
+		"holder": &counter{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	envs["join"] = convert.MakeStarFn("join", convert.Deterministic(func(s ...string) string { return strings.Join(s, ",") }))
+
+	thread := &starlark.Thread{}
+	thread.SetLocal(convert.DeterministicKey, true)
+	res, err := execWithThread(thread, `out = (double(21), join("a", "b"), holder.Next())`, envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res["out"].String(); got != `(42, "a,b", 1)` {
+		t.Fatalf("out = %s", got)
+	}
+	_, err = execWithThread(thread, `out = roll()`, envs)
+	if err == nil || !strings.Contains(err.Error(), "roll: not allowed in a deterministic run") {
+		t.Fatalf("expected rejection, got %v", err)
+	}
+	if _, err := execWithThread(&starlark.Thread{}, `out = roll()`, envs); err != nil {
+		t.Fatalf("unmarked function on a regular thread: %v", err)
+	}
+	if v, err := convert.ToValue(convert.Deterministic(func() {})); err != nil || v.Type() != "builtin_function_or_method" {
+		t.Fatalf("ToValue(Deterministic) = %v, %v", v, err)
+	}
+}
+
+// TestThreadRand verifies ThreadRand returns the source stored on the
+// thread, and a usable one otherwise.
+func TestThreadRand(t *testing.T) {
+	draw := func(th *starlark.Thread) []int {
+		r := convert.ThreadRand(th)
+		return []int{r.Intn(1000), r.Intn(1000), r.Intn(1000)}
+	}
+	a, b := &starlark.Thread{}, &starlark.Thread{}
+	a.SetLocal(convert.RandKey, newRand(7))
+	b.SetLocal(convert.RandKey, newRand(7))
+	if x, y := draw(a), draw(b); fmt.Sprint(x) != fmt.Sprint(y) {
+		t.Fatalf("same seed drew %v and %v", x, y)
+	}
+	if convert.ThreadRand(nil) == nil || convert.ThreadRand(&starlark.Thread{}) == nil {
+		t.Fatal("ThreadRand returned nil")
+	}
+	if convert.IsDeterministic(nil) || convert.IsDeterministic(&starlark.Thread{}) {
+		t.Fatal("plain threads are not deterministic")
+	}
+}
+
+func newRand(seed int64) *rand.Rand { return rand.New(rand.NewSource(seed)) }
@@ -601,7 +601,6 @@ func TestMakeStarFnArgumentType(t *testing.T) {
 				return a(10)
 			},
 			codeSnippet: `x = boo(lambda x: x * 2)`,
-			wantErr:     true,
 		},
 		{
 			name: "Call with slice for array argument (not handle yet)",
@@ -13,14 +13,14 @@ import (
 // This will panic if the value is nil or the type is not a bool, string, float kind, int kind, or uint kind.
 func MakeGoInterface(v interface{}) *GoInterface {
 	val := reflect.ValueOf(v)
-	ifc, ok := makeGoInterface(val)
+	ifc, ok := makeGoInterface(val, nil)
 	if !ok {
 		panic(fmt.Errorf("value of type %T is not supported by GoInterface", val.Interface()))
 	}
 	return ifc
 }
 
-func makeGoInterface(val reflect.Value) (*GoInterface, bool) {
+func makeGoInterface(val reflect.Value, sc *scope) (*GoInterface, bool) {
 	// we accept pointers to anything except structs, which should go through GoStruct.
 	if val.Kind() == reflect.Ptr && val.Elem().Kind() == reflect.Struct {
 		return nil, false
@@ -32,7 +32,7 @@ func makeGoInterface(val reflect.Value) (*GoInterface, bool) {
 		reflect.Float32, reflect.Float64,
 		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
 		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
-		return &GoInterface{v: val}, true
+		return &GoInterface{v: val, sc: sc}, true
 	}
 	return nil, false
 }
@@ -44,6 +44,7 @@ type GoInterface struct {
 	_   DoNotCompare
 	v   reflect.Value
 	tag string
+	sc  *scope
 }
 
 // Attr returns a starlark value that wraps the method or field with the given name.
@@ -63,7 +64,7 @@ func (g *GoInterface) Attr(name string) (starlark.Value, error) {
 
 	method := g.v.MethodByName(name)
 	if method.Kind() != reflect.Invalid && method.CanInterface() {
-		return makeStarFn(name, method, g.tag), nil
+		return makeStarFn(name, method, g.tag, g.sc, methodFn), nil
 	}
 	return nil, nil
 }
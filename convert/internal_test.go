@@ -22,7 +22,7 @@ func TestTypeCacheBounded(t *testing.T) {
 	c := newBoundedTypeCache(8)
 	for i := 0; i < 1000; i++ {
 		at := reflect.ArrayOf(i+1, emptyIfaceType)
-		c.loadOrStore(at, checkCollectionElemTypes(at, nil))
+		c.loadOrStore(at, checkCollectionElemTypes(at, nil, nil))
 	}
 	if got := c.size(); got > 8 {
 		t.Fatalf("cache exceeded cap: size=%d, cap=8", got)
@@ -30,12 +30,12 @@ func TestTypeCacheBounded(t *testing.T) {
 	// correctness is independent of caching: a value past the cap still
 	// computes the right answer
 	at := reflect.ArrayOf(5000, emptyIfaceType)
-	if err := c.loadOrStore(at, checkCollectionElemTypes(at, nil)); err != nil {
+	if err := c.loadOrStore(at, checkCollectionElemTypes(at, nil, nil)); err != nil {
 		t.Fatalf("expected nil error for [N]interface{}, got %v", err)
 	}
-	bad := reflect.TypeOf(map[string]chan int(nil))
-	if err := c.loadOrStore(bad, checkCollectionElemTypes(bad, nil)); err == nil {
-		t.Fatal("expected error for map[string]chan int")
+	bad := reflect.TypeOf(map[string]uintptr(nil))
+	if err := c.loadOrStore(bad, checkCollectionElemTypes(bad, nil, nil)); err == nil {
+		t.Fatal("expected error for map[string]uintptr")
 	}
 }
 
@@ -69,7 +69,7 @@ func TestTupleKeysDoNotLeakCache(t *testing.T) {
 func TestBoundedCacheReturnsCachedAndFresh(t *testing.T) {
 	c := newBoundedTypeCache(64)
 	mt := reflect.TypeOf(map[string]int(nil))
-	if err := c.loadOrStore(mt, checkCollectionElemTypes(mt, nil)); err != nil {
+	if err := c.loadOrStore(mt, checkCollectionElemTypes(mt, nil, nil)); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	// second call hits the cache and must agree
@@ -92,7 +92,7 @@ func TestToValuePanicSentinel(t *testing.T) {
 		t.Fatal("test setup broken: expected an unexported field value")
 	}
 
-	v, err := toValue(rv, "")
+	v, err := toValue(rv, "", nil)
 	if err == nil {
 		t.Fatalf("expected an error, got value %v", v)
 	}
@@ -0,0 +1,385 @@
+package convert
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// Binding is a Go function bound with a Starlark parameter list, made by
+// Func or Named. Pass it wherever the function would go (ToValue,
+// MakeStringDict, MakeStarFn); it can be marked with Deterministic too.
+type Binding struct {
+	fn     reflect.Value
+	kind   fnKind // hostFn, or markedFn once marked with Deterministic
+	named  bool   // Params was called
+	params []param
+	rest   string       // the name of the *rest parameter, if any
+	opts   reflect.Type // the options struct (or pointer to it), or nil
+}
+
+// param is a named parameter of a Binding.
+type param struct {
+	name     string
+	optional bool           // may be omitted
+	def      starlark.Value // the value when omitted; nil means the zero value
+	kwOnly   bool
+}
+
+var bindingType = reflect.TypeOf(&Binding{})
+
+// Func starts the binding of the Go function fn, e.g.
+//
+//	convert.Func(open).Params("path", "mode=0644", "*rest", "sync?")
+//
+// Until Params is called, the function takes positional arguments only, as
+// with MakeStarFn. Func panics if fn is not a function.
+func Func(fn interface{}) *Binding {
+	kind := hostFn
+	if d, ok := fn.(deterministicFunc); ok {
+		fn, kind = d.fn.Interface(), markedFn
+	}
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func {
+		panic(errors.New("fn is not a function"))
+	}
+	return &Binding{fn: v, kind: kind}
+}
+
+// Named binds the Go function fn with parameter names, so scripts can pass
+// its arguments by keyword as well as by position, e.g. send(to="a",
+// subject="b"). It is short for Func(fn).Params(names...).
+func Named(fn interface{}, names ...string) interface{} {
+	return Func(fn).Params(names...)
+}
+
+// Params declares the parameters scripts pass to the function, in the
+// manner of a Starlark def, and returns the resulting Binding. Each spec is
+// one of:
+//
+//	"name"       a required parameter
+//	"name=expr"  a parameter with a default: an integer in Go syntax
+//	             ("0644" is octal), or any Starlark expression ("'r'", "None")
+//	"name?"      an optional parameter, the zero value when omitted (nil for
+//	             pointers), as with starlark.UnpackArgs
+//	"*rest"      the extra positional arguments, passed to fn's variadic
+//	             parameter; the parameters after it are keyword-only
+//	"*"          makes the parameters after it keyword-only
+//
+// fn takes the named parameters in order, after a leading *starlark.Thread
+// or context.Context if any, then the variadic parameter of *rest. If fn
+// has no *rest and takes one parameter more than names, that last parameter
+// must be a struct or a pointer to a struct: an options struct, whose
+// exported fields are filled from the remaining keyword arguments, named by
+// their field tag (see NewStruct) or field name. Options are optional.
+//
+// As with native builtins, a call fails for unknown keywords, for a
+// parameter given twice, and for missing required parameters. Arguments and
+// defaults convert as positional arguments do. Params panics if a spec is
+// invalid, a default does not convert to its parameter, fn does not match
+// the specs, or the tags of its options struct have unknown options (see
+// CheckStructTags).
+func (b *Binding) Params(specs ...string) *Binding {
+	nb := &Binding{fn: b.fn, kind: b.kind, named: true}
+	ft := b.fn.Type()
+	skip := numHostArgs(ft)
+	seen := make(map[string]bool, len(specs))
+	kwOnly, optional := false, false
+	for _, s := range specs {
+		var p param
+		switch {
+		case strings.HasPrefix(s, "*"):
+			if kwOnly {
+				panic(fmt.Errorf("parameter %q follows another * parameter", s))
+			}
+			kwOnly = true
+			if s == "*" {
+				continue
+			}
+			nb.rest = s[1:]
+			p.name = nb.rest
+		case strings.Contains(s, "="):
+			i := strings.Index(s, "=")
+			p.name, p.optional = strings.TrimSpace(s[:i]), true
+			p.def = parseDefault(p.name, strings.TrimSpace(s[i+1:]))
+		case strings.HasSuffix(s, "?"):
+			p.name, p.optional = strings.TrimSuffix(s, "?"), true
+		default:
+			p.name = s
+		}
+		if !isIdent(p.name) || seen[p.name] {
+			panic(fmt.Errorf("invalid or duplicate parameter name %q", p.name))
+		}
+		seen[p.name] = true
+		if p.name == nb.rest {
+			continue
+		}
+		if !kwOnly {
+			if optional && !p.optional {
+				panic(fmt.Errorf("required parameter %s follows an optional one", p.name))
+			}
+			optional = optional || p.optional
+		}
+		p.kwOnly = kwOnly
+		nb.params = append(nb.params, p)
+	}
+
+	named := len(nb.params)
+	switch visible := ft.NumIn() - skip; {
+	case nb.rest != "":
+		if !ft.IsVariadic() || visible != named+1 {
+			panic(fmt.Errorf("fn must take %d parameters and a variadic one for *%s", named, nb.rest))
+		}
+	case ft.IsVariadic():
+		panic(errors.New("fn is variadic but has no *rest parameter"))
+	case visible == named:
+	case visible == named+1:
+		last := ft.In(ft.NumIn() - 1)
+		if !isOptionsStruct(last) {
+			panic(fmt.Errorf("fn has %d parameters for %d names, and its last parameter %s is not a struct", visible, named, last))
+		}
+		if err := typeFields(derefType(last), DefaultPropertyTag).err; err != nil {
+			panic(fmt.Errorf("options struct %s: %v", last, err))
+		}
+		nb.opts = last
+	default:
+		panic(fmt.Errorf("fn has %d parameters for %d names", visible, named))
+	}
+	for i, p := range nb.params {
+		if p.def == nil {
+			continue
+		}
+		if _, err := convertArg(nil, p.def, ft.In(i+skip)); err != nil {
+			panic(fmt.Errorf("default of parameter %s: %v", p.name, err))
+		}
+	}
+	return nb
+}
+
+// parseDefault returns the value of the default expr of parameter name.
+func parseDefault(name, expr string) starlark.Value {
+	if n, err := strconv.ParseInt(expr, 0, 64); err == nil {
+		return starlark.MakeInt64(n)
+	}
+	v, err := starlark.EvalOptions(&syntax.FileOptions{}, &starlark.Thread{Name: "default"}, name, expr, nil)
+	if err != nil {
+		panic(fmt.Errorf("default of parameter %s: %v", name, err))
+	}
+	v.Freeze()
+	return v
+}
+
+func isIdent(s string) bool {
+	for i, r := range s {
+		if r != '_' && !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func isOptionsStruct(t reflect.Type) bool {
+	return derefType(t).Kind() == reflect.Struct
+}
+
+// derefType returns the element type of t if it is a pointer type, and t
+// otherwise.
+func derefType(t reflect.Type) reflect.Type {
+	if t.Kind() == reflect.Ptr {
+		return t.Elem()
+	}
+	return t
+}
+
+// makeNamedStarFn wraps a function bound by Params, whose options struct
+// fields are named after tagName.
+func makeNamedStarFn(name string, b *Binding, tagName string, sc *scope) *starlark.Builtin {
+	var fields map[string][]int // option name -> field index
+	if b.opts != nil {
+		optTag := tagName
+		if optTag == "" {
+			optTag = DefaultPropertyTag
+		}
+		list := structFields(derefType(b.opts), optTag)
+		fields = make(map[string][]int, len(list))
+		for _, f := range list {
+			fields[f.name] = f.index
+		}
+	}
+	npos := 0
+	for _, p := range b.params {
+		if !p.kwOnly {
+			npos++
+		}
+	}
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (sv starlark.Value, ef error) {
+		// traced with the arguments by parameter, once bound
+		traced := args
+		if tr := threadTracer(thread); tr != nil {
+			start := time.Now()
+			defer func() { tr.report(thread, name, traced, start, sv, ef) }()
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				sv = starlark.None
+				ef = panicError(name, r)
+			}
+		}()
+
+		if b.kind == hostFn && IsDeterministic(thread) {
+			return starlark.None, fmt.Errorf("%s: not allowed in a deterministic run (not marked with convert.Deterministic)", name)
+		}
+		var rest starlark.Tuple
+		if len(args) > npos {
+			if b.rest == "" {
+				return starlark.None, fmt.Errorf("%s: got %d arguments, want at most %d", name, len(args), npos)
+			}
+			args, rest = args[:npos], args[npos:]
+		}
+		bound := make(starlark.Tuple, len(b.params))
+		copy(bound, args)
+		var opts []starlark.Tuple
+		for _, kw := range kwargs {
+			k := string(kw[0].(starlark.String))
+			i := b.index(k)
+			_, isOpt := fields[k]
+			switch {
+			case i >= 0 && bound[i] != nil:
+				return starlark.None, fmt.Errorf("%s: got multiple values for keyword argument %s", name, k)
+			case i >= 0:
+				bound[i] = kw[1]
+			case isOpt:
+				opts = append(opts, kw)
+			default:
+				return starlark.None, fmt.Errorf("%s: unexpected keyword argument %s", name, k)
+			}
+		}
+		for i, p := range b.params {
+			if bound[i] != nil {
+				continue
+			}
+			if !p.optional {
+				return starlark.None, fmt.Errorf("%s: missing argument for %s", name, p.name)
+			}
+			bound[i] = p.def
+		}
+
+		ft := b.fn.Type()
+		skip := numHostArgs(ft)
+		sc := callScope(thread, sc)
+		cbs := newCallbacks(thread, name, tagName, sc)
+		defer cbs.finish(&ef)
+		rvs := appendHostArgs(make([]reflect.Value, 0, ft.NumIn()+len(rest)), ft, thread)
+		for i, v := range bound {
+			argT := ft.In(i + skip)
+			if v == nil {
+				rvs = append(rvs, reflect.Zero(argT))
+				bound[i] = starlark.None
+				continue
+			}
+			val, err := convertArg(cbs, v, argT)
+			if err != nil {
+				return starlark.None, fmt.Errorf("%s: for parameter %s: %v", name, b.params[i].name, err)
+			}
+			rvs = append(rvs, val)
+		}
+		traced = bound
+		if b.rest != "" {
+			vtype := ft.In(ft.NumIn() - 1).Elem()
+			for i, v := range rest {
+				val, err := convertArg(cbs, v, vtype)
+				if err != nil {
+					return starlark.None, fmt.Errorf("%s: for parameter %s[%d]: %v", name, b.rest, i, err)
+				}
+				rvs = append(rvs, val)
+			}
+			traced = append(bound, rest)
+		}
+		if b.opts != nil {
+			ov, err := fillOptions(cbs, name, b.opts, fields, opts)
+			if err != nil {
+				return starlark.None, err
+			}
+			rvs = append(rvs, ov)
+			d := starlark.NewDict(len(opts))
+			for _, kw := range opts {
+				_ = d.SetKey(kw[0], kw[1])
+			}
+			traced = append(bound, d)
+		}
+
+		out := b.fn.Call(rvs)
+		return makeOut(out, tagName, sc)
+	})
+}
+
+// convertArg converts the argument v to the parameter type t, as
+// positional arguments are, adapting callables with cbs; a value for a
+// pointer parameter is converted to the pointed-to type and passed by
+// address.
+func convertArg(cbs *callbacks, v starlark.Value, t reflect.Type) (reflect.Value, error) {
+	if cb, ok := cbs.adapt(v, t); ok {
+		return cb, nil
+	}
+	var sc *scope // checking the defaults of Params, with no call
+	if cbs != nil {
+		sc = cbs.sc
+	}
+	if rv, ok, err := sc.fromRegistered(v, t); ok {
+		return rv, err
+	}
+	val := reflect.ValueOf(FromValue(v))
+	if t.Kind() == reflect.Ptr && val.IsValid() && !val.Type().AssignableTo(t) {
+		elem, err := convertReflectValue(val, t.Elem(), sc)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		ptr := reflect.New(t.Elem())
+		ptr.Elem().Set(elem)
+		return ptr, nil
+	}
+	return convertReflectValue(val, t, sc)
+}
+
+// index returns the position of the parameter name, or -1.
+func (b *Binding) index(name string) int {
+	for i, p := range b.params {
+		if p.name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// fillOptions returns a new options struct of type t (a struct or a pointer
+// to one) whose fields are set from kwargs.
+func fillOptions(cbs *callbacks, name string, t reflect.Type, fields map[string][]int, kwargs []starlark.Tuple) (reflect.Value, error) {
+	st := t
+	if t.Kind() == reflect.Ptr {
+		st = t.Elem()
+	}
+	ptr := reflect.New(st)
+	for _, kw := range kwargs {
+		k := string(kw[0].(starlark.String))
+		f, err := fieldByIndexAlloc(ptr.Elem(), fields[k])
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%s: for parameter %s: %v", name, k, err)
+		}
+		val, err := convertArg(cbs, kw[1], f.Type())
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%s: for parameter %s: %v", name, k, err)
+		}
+		f.Set(val)
+	}
+	if t.Kind() == reflect.Ptr {
+		return ptr, nil
+	}
+	return ptr.Elem(), nil
+}
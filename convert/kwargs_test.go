@@ -0,0 +1,174 @@
+package convert_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+type sendOptions struct {
+	CC       []string `starlark:"cc"`
+	Priority int      `starlark:"priority"`
+	Draft    bool
+	internal string
+}
+
+// TestNamed verifies functions bound by Named accept positional and keyword
+// arguments, with optional parameters and an options struct.
+func TestNamed(t *testing.T) {
+	send := func(ctx context.Context, to, subject string, opts *sendOptions) string {
+		return fmt.Sprintf("%s|%s|%v|%d|%v", to, subject, opts.CC, opts.Priority, opts.Draft)
+	}
+	envs, err := convert.MakeStringDict(map[string]interface{}{
+		"send":   convert.Named(send, "to", "subject?"),
+		"add":    convert.Named(func(a, b int) int { return a + b }, "a", "b"),
+		"config": convert.Named(func(o sendOptions) int { return o.Priority }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := execWithThread(&starlark.Thread{}, `
+out = [
+    send("a", "hi"),
+    send(to="a", subject="hi", cc=["b", "c"], priority=2),
+    send("a", Draft=True),
+    add(1, b=2),
+    add(b=5, a=1),
+    config(priority=9),
+]
+`, envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `["a|hi|[]|0|false", "a|hi|[b c]|2|false", "a||[]|0|true", 3, 6, 9]`
+	if got := res["out"].String(); got != want {
+		t.Fatalf("out = %s, want %s", got, want)
+	}
+
+	for script, msg := range map[string]string{
+		`add(1, 2, 3)`:            "add: got 3 arguments, want at most 2",
+		`add(1, a=2)`:             "add: got multiple values for keyword argument a",
+		`add(1)`:                  "add: missing argument for b",
+		`add(1, c=2)`:             "add: unexpected keyword argument c",
+		`add(1, b="x")`:           "add: for parameter b:",
+		`send("a", internal=1)`:   "send: unexpected keyword argument internal",
+		`send("a", priority="x")`: "send: for parameter priority:",
+		`config(1)`:               "config: got 1 arguments, want at most 0",
+	} {
+		if _, err := execWithThread(&starlark.Thread{}, script, envs); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: expected %q, got %v", script, msg, err)
+		}
+	}
+}
+
+// TestNamedMarked verifies Named composes with Deterministic and MakeStarFn,
+// and panics for functions that do not match their names.
+func TestNamedMarked(t *testing.T) {
+	double := func(n int) int { return n * 2 }
+	envs := starlark.StringDict{
+		"a": convert.MakeStarFn("a", convert.Named(convert.Deterministic(double), "n")),
+		"b": convert.MakeStarFn("b", convert.Deterministic(convert.Named(double, "n"))),
+		"c": convert.MakeStarFn("c", convert.Named(double, "n")),
+	}
+	thread := &starlark.Thread{}
+	thread.SetLocal(convert.DeterministicKey, true)
+	res, err := execWithThread(thread, `out = (a(n=1), b(2))`, envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res["out"].String(); got != "(2, 4)" {
+		t.Fatalf("out = %s", got)
+	}
+	if _, err := execWithThread(thread, `c(n=1)`, envs); err == nil || !strings.Contains(err.Error(), "c: not allowed") {
+		t.Fatalf("expected rejection, got %v", err)
+	}
+
+	for name, bind := range map[string]func(){
+		"not a function": func() { convert.Named(1) },
+		"variadic":       func() { convert.Named(func(s ...string) {}, "s") },
+		"too few names":  func() { convert.Named(func(a, b int) {}, "a") },
+		"duplicate name": func() { convert.Named(func(a, b int) {}, "a", "a?") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			bind()
+		}()
+	}
+}
+
+type fileMode uint32
+
+// TestFuncParams verifies the parameter lists of Func: defaults, optional
+// pointers, *rest and keyword-only parameters.
+func TestFuncParams(t *testing.T) {
+	open := func(path string, mode fileMode, flags *int, sync bool, rest ...string) string {
+		f := "nil"
+		if flags != nil {
+			f = fmt.Sprint(*flags)
+		}
+		return fmt.Sprintf("%s|%o|%s|%v|%q", path, mode, f, sync, rest)
+	}
+	envs, err := convert.MakeStringDict(map[string]interface{}{
+		"open":  convert.Func(open).Params("path", "mode=0644", "flags?", "*rest", "sync=False"),
+		"greet": convert.Func(func(name, greeting string) string { return greeting + " " + name }).Params("name", "*", "greeting='hello'"),
+		"plain": convert.Func(func(a, b int) int { return a - b }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := execWithThread(&starlark.Thread{}, `
+out = [
+    open("a"),
+    open("a", 0o600, 1),
+    open("a", flags=None, sync=True),
+    open("a", 0o600, 2, "x", "y"),
+    greet("bob"),
+    greet("bob", greeting="hi"),
+    plain(5, 3),
+]
+`, envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `["a|644|nil|false|[]", "a|600|1|false|[]", "a|644|nil|true|[]", "a|600|2|false|[\"x\" \"y\"]", "hello bob", "hi bob", 2]`
+	if got := res["out"].String(); got != want {
+		t.Fatalf("out = %s, want %s", got, want)
+	}
+
+	for script, msg := range map[string]string{
+		`greet("bob", "hi")`:      "greet: got 2 arguments, want at most 1",
+		`open("a", 1, 2, "x", 3)`: "open: for parameter rest[1]:",
+		`open("a", mode=-1)`:      "open: for parameter mode:",
+		`plain(a=1, b=2)`:         "positional arguments only",
+	} {
+		if _, err := execWithThread(&starlark.Thread{}, script, envs); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: expected %q, got %v", script, msg, err)
+		}
+	}
+
+	for name, bind := range map[string]func(){
+		"bad default":      func() { convert.Func(func(n int) {}).Params("n='x'") },
+		"bad expression":   func() { convert.Func(func(n int) {}).Params("n=(") },
+		"required after":   func() { convert.Func(func(a, b int) {}).Params("a=1", "b") },
+		"rest not varargs": func() { convert.Func(func(a int) {}).Params("*rest") },
+		"two stars":        func() { convert.Func(func(a int, s ...int) {}).Params("*", "*s", "a") },
+		"bad name":         func() { convert.Func(func(a int) {}).Params("1a") },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected a panic", name)
+				}
+			}()
+			bind()
+		}()
+	}
+}
@@ -22,6 +22,7 @@ type GoMap struct {
 	v      reflect.Value
 	numIt  int32 // accessed atomically: concurrent iterations are allowed
 	tag    string
+	sc     *scope
 	frozen bool
 }
 
@@ -32,7 +33,7 @@ var (
 
 // NewGoMap wraps the given map m in a new GoMap.
 // This function will panic if m is nil or not a map, or if its key or element
-// type cannot be converted to Starlark (e.g. a chan or complex element) — the
+// type cannot be converted to Starlark (e.g. a uintptr or unsafe.Pointer element) — the
 // same static check ToValue applies. Without it the wrapper constructs fine
 // but later panics inside Items/Keys/iteration, which cannot return an error;
 // rejecting at construction keeps those methods panic-free (invariant: methods
@@ -62,11 +63,11 @@ func (g *GoMap) SetKey(k, v starlark.Value) (err error) {
 		return fmt.Errorf("cannot insert into nil map")
 	}
 
-	key, err := tryKeyConv(k, g.v.Type().Key())
+	key, err := tryKeyConv(k, g.v.Type().Key(), g.sc)
 	if err != nil {
 		return fmt.Errorf("setkey key: %v", err)
 	}
-	val, err := tryConv(v, g.v.Type().Elem())
+	val, err := tryConv(v, g.v.Type().Elem(), g.sc)
 	if err != nil {
 		return fmt.Errorf("setkey value: %v", err)
 	}
@@ -78,7 +79,7 @@ func (g *GoMap) SetKey(k, v starlark.Value) (err error) {
 // Get implements starlark.Mapping.
 func (g *GoMap) Get(in starlark.Value) (out starlark.Value, found bool, err error) {
 	//v := g.v.MapIndex(conv(in, g.v.Type().Key()))
-	key, err := tryKeyConv(in, g.v.Type().Key())
+	key, err := tryKeyConv(in, g.v.Type().Key(), g.sc)
 	if err != nil {
 		return nil, false, fmt.Errorf("get: %v", err)
 	}
@@ -87,10 +88,11 @@ func (g *GoMap) Get(in starlark.Value) (out starlark.Value, found bool, err erro
 		return starlark.None, false, nil
 	}
 
-	val, err := toValue(v, g.tag)
+	val, err := toValue(v, g.tag, g.sc)
 	if err != nil {
 		return nil, false, err
 	}
+	val = freezeIf(val, g.frozen)
 
 	return val, true, nil
 }
@@ -113,9 +115,10 @@ func (g *GoMap) Value() reflect.Value {
 }
 
 // Freeze marks this wrapper as frozen: mutations through this GoMap
-// fail afterwards. The freeze is shallow — it does not propagate to the
-// wrapped Go map, which the host (or other wrappers around the same
-// value) can still mutate.
+// fail afterwards, and so do those through the wrappers it returns for the
+// values. The freeze does not propagate to the wrapped Go map, which the
+// host (or other wrappers around the same value) can still mutate, nor
+// stop the methods of the values from changing them.
 func (g *GoMap) Freeze() {
 	g.frozen = true
 }
@@ -152,7 +155,7 @@ func (g *GoMap) Delete(k starlark.Value) (v starlark.Value, found bool, err erro
 	if atomic.LoadInt32(&g.numIt) > 0 {
 		return nil, false, fmt.Errorf("cannot delete from map during iteration")
 	}
-	key, err := tryKeyConv(k, g.v.Type().Key())
+	key, err := tryKeyConv(k, g.v.Type().Key(), g.sc)
 	if err != nil {
 		return nil, false, fmt.Errorf("delete: %v", err)
 	}
@@ -166,7 +169,7 @@ func (g *GoMap) delete(key reflect.Value) (v starlark.Value, found bool, err err
 	}
 	g.v.SetMapIndex(key, reflect.Value{})
 
-	ret, err := toValue(val, g.tag)
+	ret, err := toValue(val, g.tag, g.sc)
 	if err != nil {
 		return starlark.None, true, err
 	}
@@ -178,14 +181,15 @@ func (g *GoMap) Items() []starlark.Tuple {
 	var err error
 	for _, k := range sortedMapKeys(g.v) {
 		tuple := make(starlark.Tuple, 2)
-		tuple[0], err = toValue(k, g.tag)
+		tuple[0], err = toValue(k, g.tag, g.sc)
 		if err != nil {
 			panic(err)
 		}
-		tuple[1], err = toValue(g.v.MapIndex(k), g.tag)
+		tuple[1], err = toValue(g.v.MapIndex(k), g.tag, g.sc)
 		if err != nil {
 			panic(err)
 		}
+		freezeIf(tuple[1], g.frozen)
 		tuples = append(tuples, tuple)
 	}
 	return tuples
@@ -194,7 +198,7 @@ func (g *GoMap) Items() []starlark.Tuple {
 func (g *GoMap) Keys() []starlark.Value {
 	keys := make([]starlark.Value, 0, g.v.Len())
 	for _, k := range sortedMapKeys(g.v) {
-		key, err := toValue(k, g.tag)
+		key, err := toValue(k, g.tag, g.sc)
 		if err != nil {
 			panic(err)
 		}
@@ -268,7 +272,7 @@ type mapIterator struct {
 
 func (it *mapIterator) Next(p *starlark.Value) bool {
 	if it.i < len(it.keys) {
-		v, err := toValue(it.keys[it.i], it.g.tag)
+		v, err := toValue(it.keys[it.i], it.g.tag, it.g.sc)
 		if err != nil {
 			panic(err)
 		}
@@ -372,7 +376,7 @@ func dict_popitem(fnname string, g *GoMap, args starlark.Tuple, _ []starlark.Tup
 	if err != nil {
 		return nil, err
 	}
-	key, err := toValue(k, g.tag)
+	key, err := toValue(k, g.tag, g.sc)
 	if err != nil {
 		return nil, err
 	}
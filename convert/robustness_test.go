@@ -6,6 +6,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"unsafe"
 
 	"github.com/1set/starlight"
 	"github.com/1set/starlight/convert"
@@ -120,12 +121,12 @@ assert.Eq(a[0], 10)
 // iteration.
 func TestUnsupportedElemType(t *testing.T) {
 	for _, v := range []interface{}{
-		map[string]chan int{"c": make(chan int)},
-		[]chan int{make(chan int)},
-		map[chan int]string{},
-		map[string][]chan int{},
-		[][]chan int{},
-		[]complex128{1i},
+		map[string]uintptr{"c": 1},
+		[]uintptr{1},
+		map[uintptr]string{},
+		map[string][]uintptr{},
+		[][]uintptr{},
+		[]unsafe.Pointer{nil},
 	} {
 		if _, err := convert.ToValue(v); err == nil {
 			t.Errorf("expected conversion error for %T, got nil", v)
@@ -142,11 +143,11 @@ func TestUnsupportedElemType(t *testing.T) {
 
 	// script-level: the error must surface as a regular error, not a panic
 	globals := map[string]interface{}{
-		"m": map[string]chan int{"c": make(chan int)},
+		"m": map[string]uintptr{"c": 1},
 	}
 	_, err := starlight.Eval([]byte(`x = len(m)`), globals, nil)
 	if err == nil {
-		t.Fatal("expected conversion error for chan-valued map global")
+		t.Fatal("expected conversion error for uintptr-valued map global")
 	}
 }
 
@@ -159,16 +160,16 @@ func TestUnsupportedElemType(t *testing.T) {
 // methods that can't return errors must never reach panic).
 func TestConstructorPrechecksElemTypes(t *testing.T) {
 	for _, m := range []interface{}{
-		map[string]chan int{"c": make(chan int)},
-		map[complex128]string{},
-		map[string][]chan int{},
+		map[string]uintptr{"c": 1},
+		map[unsafe.Pointer]string{},
+		map[string][]uintptr{},
 	} {
 		assertConstructPanics(t, fmt.Sprintf("NewGoMap(%T)", m), func() { convert.NewGoMap(m) })
 	}
 	for _, s := range []interface{}{
-		[]chan int{make(chan int)},
-		[]complex128{1i},
-		[2]chan int{},
+		[]uintptr{1},
+		[]unsafe.Pointer{nil},
+		[2]uintptr{},
 	} {
 		assertConstructPanics(t, fmt.Sprintf("NewGoSlice(%T)", s), func() { convert.NewGoSlice(s) })
 	}
@@ -226,9 +227,9 @@ func TestRecursiveTypeDefinition(t *testing.T) {
 	}
 	// a recursive type that bottoms out in an unsupported element still
 	// terminates and reports the error rather than hanging
-	type badRec map[string]chan int
+	type badRec map[string]uintptr
 	if _, err := convert.ToValue(badRec{}); err == nil {
-		t.Fatal("expected error for recursive-shaped type with chan element")
+		t.Fatal("expected error for recursive-shaped type with uintptr element")
 	}
 }
 
@@ -8,6 +8,7 @@ import (
 	"sync/atomic"
 
 	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
 )
 
 // Much of this code is derived in large part from starlark-go's List
@@ -19,19 +20,28 @@ import (
 // starlark. Arrays are copied into a slice at wrap time: they arrive by
 // value (unaddressable) through interface{}, so in-place mutation could
 // never reach the host's array anyway — mutations affect the copy only.
+//
+// A GoSlice behaves like a list with three differences scripts can see: it
+// never equals a list, even with the same elements (compare list(s)
+// instead); s + x is a GoSlice of the same Go type, so it fails if an
+// element of x does not convert to the element type (list + s is a list);
+// and s += x binds s to a new GoSlice rather than growing s in place, so
+// other references to it, and the host, do not see the new elements (use
+// s.extend(x) instead).
 type GoSlice struct {
 	_      DoNotCompare
 	v      reflect.Value
 	numIt  int32 // accessed atomically: concurrent iterations are allowed
 	tag    string
+	sc     *scope
 	frozen bool
 }
 
 // NewGoSlice wraps the given slice or array in a new GoSlice; arrays are
 // copied into a slice (see the GoSlice doc).
 // This function will panic if the argument is not a slice nor an array, or if
-// its element type cannot be converted to Starlark (e.g. a chan or complex
-// element) — the same static check ToValue applies. Without it the wrapper
+// its element type cannot be converted to Starlark (e.g. a uintptr or
+// unsafe.Pointer element) — the same static check ToValue applies. Without it the wrapper
 // constructs fine but later panics inside Index/iteration, which cannot return
 // an error; rejecting at construction keeps those methods panic-free
 // (invariant: methods that can't return errors must never reach panic).
@@ -77,9 +87,10 @@ func (g *GoSlice) Value() reflect.Value {
 }
 
 // Freeze marks this wrapper as frozen: mutations through this GoSlice
-// fail afterwards. The freeze is shallow — it does not propagate to the
-// wrapped Go slice, which the host (or other wrappers around the same
-// value) can still mutate.
+// fail afterwards, and so do those through the wrappers it returns for the
+// elements. The freeze does not propagate to the wrapped Go slice, which
+// the host (or other wrappers around the same value) can still mutate, nor
+// stop the methods of the elements from changing them.
 func (g *GoSlice) Freeze() {
 	g.frozen = true
 }
@@ -96,6 +107,166 @@ func (g *GoSlice) Hash() (uint32, error) {
 	return 0, errors.New("starlight_slice is not hashable")
 }
 
+var (
+	_ starlark.HasBinary  = (*GoSlice)(nil)
+	_ starlark.Comparable = (*GoSlice)(nil)
+	_ starlark.Container  = (*GoSlice)(nil)
+)
+
+// maxRepeat bounds the length of the slices built by *, as Starlark bounds
+// its lists.
+const maxRepeat = 1 << 30
+
+// Binary implements + with lists and other slices, and * with ints, as
+// list does. The result has the type of the left operand: list + s is a
+// list, and s + y a GoSlice of the Go type of s, which fails if an element
+// of y does not convert to its element type. Starlark only extends lists
+// in place for +=, so x += y binds x to a new GoSlice; use x.extend(y) to
+// grow x itself.
+func (g *GoSlice) Binary(op syntax.Token, y starlark.Value, side starlark.Side) (starlark.Value, error) {
+	switch op {
+	case syntax.PLUS:
+		switch y := y.(type) {
+		case *starlark.List:
+			if side == starlark.Right {
+				return g.appendTo(elemsOf(y))
+			}
+			return g.concat(y)
+		case *GoSlice:
+			// side is Left: y handles g + y itself
+			return g.concat(y)
+		}
+	case syntax.STAR:
+		if n, ok := y.(starlark.Int); ok {
+			return g.repeat(n)
+		}
+	}
+	return nil, nil // unhandled
+}
+
+// elemsOf returns the elements of the list l.
+func elemsOf(l *starlark.List) []starlark.Value {
+	elems := make([]starlark.Value, l.Len())
+	for i := range elems {
+		elems[i] = l.Index(i)
+	}
+	return elems
+}
+
+// appendTo returns the list of elems followed by the elements of g.
+func (g *GoSlice) appendTo(elems []starlark.Value) (starlark.Value, error) {
+	for i := 0; i < g.v.Len(); i++ {
+		v, err := toValue(g.v.Index(i), g.tag, g.sc)
+		if err != nil {
+			return nil, err
+		}
+		elems = append(elems, freezeIf(v, g.frozen))
+	}
+	return starlark.NewList(elems), nil
+}
+
+// concat returns the elements of g followed by those of the sequence y,
+// converted to the element type of g, as a GoSlice of the type of g.
+func (g *GoSlice) concat(y starlark.Indexable) (starlark.Value, error) {
+	n := g.v.Len()
+	cp := reflect.MakeSlice(g.v.Type(), n, n+y.Len())
+	reflect.Copy(cp, g.v)
+	et := g.v.Type().Elem()
+	for i := 0; i < y.Len(); i++ {
+		v, err := tryConv(y.Index(i), et, g.sc)
+		if err != nil {
+			return nil, fmt.Errorf("+: element %d: %v", i, err)
+		}
+		cp = reflect.Append(cp, v)
+	}
+	return &GoSlice{v: cp, tag: g.tag, sc: g.sc}, nil
+}
+
+// repeat returns a GoSlice of the type of g repeating its elements n
+// times.
+func (g *GoSlice) repeat(n starlark.Int) (starlark.Value, error) {
+	times, err := starlark.AsInt32(n)
+	if err != nil {
+		return nil, fmt.Errorf("repeat count %s too large", n)
+	}
+	if times < 0 {
+		times = 0
+	}
+	l := g.v.Len()
+	if l > 0 && int(times) > maxRepeat/l {
+		return nil, fmt.Errorf("excessive repeat (%d * %d elements)", l, times)
+	}
+	cp := reflect.MakeSlice(g.v.Type(), 0, l*int(times))
+	for i := 0; i < int(times); i++ {
+		cp = reflect.AppendSlice(cp, g.v)
+	}
+	return &GoSlice{v: cp, tag: g.tag, sc: g.sc}, nil
+}
+
+// Has reports whether the slice holds an element equal to y, for the in
+// operator.
+func (g *GoSlice) Has(y starlark.Value) (bool, error) {
+	for i := 0; i < g.v.Len(); i++ {
+		v, err := toValue(g.v.Index(i), g.tag, g.sc)
+		if err != nil {
+			return false, err
+		}
+		if eq, err := starlark.Equal(v, y); err != nil {
+			return false, err
+		} else if eq {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// CompareSameType compares slices element by element, as lists are
+// compared, whatever their Go types. Starlark deems values of different
+// types unequal before consulting them, so a GoSlice never equals a list.
+func (g *GoSlice) CompareSameType(op syntax.Token, y starlark.Value, depth int) (bool, error) {
+	h := y.(*GoSlice)
+	xn, yn := g.v.Len(), h.v.Len()
+	if xn != yn && (op == syntax.EQL || op == syntax.NEQ) {
+		return op == syntax.NEQ, nil
+	}
+	for i := 0; i < xn && i < yn; i++ {
+		a, err := toValue(g.v.Index(i), g.tag, g.sc)
+		if err != nil {
+			return false, err
+		}
+		b, err := toValue(h.v.Index(i), h.tag, h.sc)
+		if err != nil {
+			return false, err
+		}
+		if eq, err := starlark.EqualDepth(a, b, depth-1); err != nil {
+			return false, err
+		} else if !eq {
+			switch op {
+			case syntax.EQL:
+				return false, nil
+			case syntax.NEQ:
+				return true, nil
+			}
+			return starlark.CompareDepth(op, a, b, depth-1)
+		}
+	}
+	switch op {
+	case syntax.EQL:
+		return xn == yn, nil
+	case syntax.NEQ:
+		return xn != yn, nil
+	case syntax.LT:
+		return xn < yn, nil
+	case syntax.LE:
+		return xn <= yn, nil
+	case syntax.GT:
+		return xn > yn, nil
+	case syntax.GE:
+		return xn >= yn, nil
+	}
+	return false, fmt.Errorf("%s %s %s not implemented", g.Type(), op, y.Type())
+}
+
 func (g *GoSlice) Clear() error {
 	if err := g.checkMutable("clear"); err != nil {
 		return err
@@ -106,18 +277,18 @@ func (g *GoSlice) Clear() error {
 
 // Index implements starlark.Indexable.
 func (g *GoSlice) Index(i int) starlark.Value {
-	v, err := toValue(g.v.Index(i), g.tag)
+	v, err := toValue(g.v.Index(i), g.tag, g.sc)
 	if err != nil {
 		panic(err)
 	}
-	return v
+	return freezeIf(v, g.frozen)
 }
 
 func (g *GoSlice) SetIndex(index int, v starlark.Value) error {
 	if err := g.checkMutable("assign to"); err != nil {
 		return err
 	}
-	val, err := tryConv(v, g.v.Type().Elem())
+	val, err := tryConv(v, g.v.Type().Elem(), g.sc)
 	if err != nil {
 		return fmt.Errorf("index: %v", err)
 	}
@@ -130,14 +301,14 @@ func (g *GoSlice) Slice(start, end, step int) starlark.Value {
 	if step == 1 {
 		cp := reflect.MakeSlice(g.v.Type(), end-start, end-start)
 		reflect.Copy(cp, g.v.Slice(start, end))
-		return &GoSlice{v: cp}
+		return &GoSlice{v: cp, sc: g.sc}
 	}
 	cp := reflect.MakeSlice(g.v.Type(), 0, 0)
 	sign := signOf(step)
 	for i := start; signOf(end-i) == sign; i += step {
 		cp = reflect.Append(cp, g.v.Index(i))
 	}
-	return &GoSlice{v: cp}
+	return &GoSlice{v: cp, sc: g.sc}
 }
 
 func signOf(i int) int {
@@ -213,11 +384,11 @@ type sliceIterator struct {
 
 func (it *sliceIterator) Next(p *starlark.Value) bool {
 	if it.i < it.g.v.Len() {
-		v, err := toValue(it.g.v.Index(it.i), it.g.tag)
+		v, err := toValue(it.g.v.Index(it.i), it.g.tag, it.g.sc)
 		if err != nil {
 			panic(err)
 		}
-		*p = v
+		*p = freezeIf(v, it.g.frozen)
 		it.i++
 		return true
 	}
@@ -248,7 +419,7 @@ func list_append(fnname string, g *GoSlice, args starlark.Tuple, kwargs []starla
 	if err := g.checkMutable("append to"); err != nil {
 		return nil, err
 	}
-	v, err := tryConv(args[0], g.v.Type().Elem())
+	v, err := tryConv(args[0], g.v.Type().Elem(), g.sc)
 	if err != nil {
 		return nil, fmt.Errorf("append: %v", err)
 	}
@@ -281,7 +452,7 @@ func list_extend(fnname string, g *GoSlice, args starlark.Tuple, kwargs []starla
 	it := iterable.Iterate()
 	defer it.Done()
 	for it.Next(&val) {
-		v, err := tryConv(val, g.v.Type().Elem())
+		v, err := tryConv(val, g.v.Type().Elem(), g.sc)
 		if err != nil {
 			return nil, fmt.Errorf("extend: %v", err)
 		}
@@ -329,7 +500,7 @@ func findElement(fnname string, g *GoSlice, args starlark.Tuple, kwargs []starla
 		return -1, fmt.Errorf("%s: expected 1-3 args, got %d", fnname, len(args))
 	}
 
-	value, err := tryConv(args[0], g.v.Type().Elem())
+	value, err := tryConv(args[0], g.v.Type().Elem(), g.sc)
 	if err != nil {
 		return -1, fmt.Errorf("%s: %v", fnname, err)
 	}
@@ -364,7 +535,7 @@ func list_insert(fnname string, g *GoSlice, args starlark.Tuple, kwargs []starla
 		index += g.v.Len()
 	}
 
-	val, err := tryConv(args[1], g.v.Type().Elem())
+	val, err := tryConv(args[1], g.v.Type().Elem(), g.sc)
 	if err != nil {
 		return starlark.None, fmt.Errorf("insert: %v", err)
 	}
@@ -390,7 +561,7 @@ func list_remove(fnname string, g *GoSlice, args starlark.Tuple, kwargs []starla
 		return nil, err
 	}
 
-	val, err := tryConv(args[0], g.v.Type().Elem())
+	val, err := tryConv(args[0], g.v.Type().Elem(), g.sc)
 	if err != nil {
 		return nil, fmt.Errorf("remove: %v", err)
 	}
@@ -428,7 +599,7 @@ func list_pop(fnname string, g *GoSlice, args starlark.Tuple, kwargs []starlark.
 		return nil, err
 	}
 	// convert this out before reslicing, otherwise the value changes out from under us.
-	res, err := toValue(g.v.Index(index), g.tag)
+	res, err := toValue(g.v.Index(index), g.tag, g.sc)
 	if err != nil {
 		return nil, err
 	}
@@ -409,11 +409,11 @@ func TestSliceUnsupportedType(t *testing.T) {
 	// conversion time, before any script runs (previously they wrapped fine
 	// and every later access errored or panicked)
 	for _, sl := range []interface{}{
-		[]chan int{},
-		[]chan int{make(chan int), make(chan int, 1), make(chan int, 2)},
+		[]uintptr{},
+		[]uintptr{1, 2, 3},
 	} {
 		_, err := starlight.Eval([]byte(`x = 1`), map[string]interface{}{"s": sl}, nil)
-		expectErr(t, err, "type chan int is not a supported starlark type")
+		expectErr(t, err, "type uintptr is not a supported starlark type")
 	}
 
 	// indexing errors on supported slices stay graceful
@@ -447,3 +447,64 @@ func TestSliceUnsupportedType(t *testing.T) {
 // 		t.Fatal(err)
 // 	}
 // }
+
+func TestSliceOperators(t *testing.T) {
+	x3 := []int{1, 2, 3}
+	globals := map[string]interface{}{
+		"assert":   &assert{t: t},
+		"x3":       x3,
+		"y3":       []int{1, 2, 4},
+		"names":    []string{"b", "a"},
+		"intSlice": intSlice,
+	}
+
+	code := []byte(`
+assert.Eq(x3 + [4], intSlice([1, 2, 3, 4]))
+assert.Eq(type(x3 + [4]), type(x3))
+assert.Eq([0] + x3, [0, 1, 2, 3])
+assert.Eq(x3 + y3, intSlice([1, 2, 3, 1, 2, 4]))
+assert.Eq(["a"] + x3, ["a", 1, 2, 3])
+assert.Eq(x3 * 2, intSlice([1, 2, 3, 1, 2, 3]))
+assert.Eq(2 * x3, intSlice([1, 2, 3, 1, 2, 3]))
+assert.Eq(len(x3 * 0), 0)
+assert.Eq(len(x3 * -1), 0)
+assert.Eq(2 in x3, True)
+assert.Eq(5 in x3, False)
+assert.Eq(5 not in x3, True)
+assert.Eq(x3 == intSlice([1, 2, 3]), True)
+assert.Eq(x3 == [1, 2, 3], False)
+assert.Eq(list(x3) == [1, 2, 3], True)
+assert.Eq(x3 != y3, True)
+assert.Eq(x3 < y3, True)
+assert.Eq(x3 >= y3, False)
+assert.Eq(x3 < x3 + [0], True)
+assert.Eq(sorted([y3, x3])[0], x3)
+assert.Eq(sorted(names), ["a", "b"])
+
+def grow(z):
+	z += [4]
+	return z
+assert.Eq(grow(x3), intSlice([1, 2, 3, 4]))
+assert.Eq(x3, intSlice([1, 2, 3]))
+`)
+	_, err := starlight.Eval(code, globals, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code = []byte(`x3 * 1000000000000`)
+	_, err = starlight.Eval(code, globals, nil)
+	expectErr(t, err, "repeat count 1000000000000 too large")
+
+	code = []byte(`x3 + [4, "a"]`)
+	_, err = starlight.Eval(code, globals, nil)
+	expectErr(t, err, "+: element 1: value of type string cannot be converted to type int")
+
+	code = []byte(`x3 + names`)
+	_, err = starlight.Eval(code, globals, nil)
+	expectErr(t, err, "+: element 0: value of type string cannot be converted to type int")
+
+	code = []byte(`x3 < names`)
+	_, err = starlight.Eval(code, globals, nil)
+	expectErr(t, err, "int < string not implemented")
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 
 	"go.starlark.net/starlark"
 )
@@ -43,6 +44,7 @@ type GoStruct struct {
 	_      DoNotCompare
 	v      reflect.Value
 	tag    string
+	sc     *scope
 	frozen bool
 }
 
@@ -97,7 +99,7 @@ func (g *GoStruct) Attr(name string) (starlark.Value, error) {
 	// check for its methods and its pointer's methods
 	method := g.v.MethodByName(name)
 	if method.Kind() != reflect.Invalid && method.CanInterface() {
-		return makeStarFn(name, method, g.tag), nil
+		return makeStarFn(name, method, g.tag, g.sc, methodFn), nil
 	}
 	v := g.v
 	if g.v.Kind() == reflect.Ptr {
@@ -109,40 +111,30 @@ func (g *GoStruct) Attr(name string) (starlark.Value, error) {
 		// visible to the host
 		method = g.v.Addr().MethodByName(name)
 		if method.Kind() != reflect.Invalid && method.CanInterface() {
-			return makeStarFn(name, method, g.tag), nil
+			return makeStarFn(name, method, g.tag, g.sc, methodFn), nil
 		}
 	}
 
-	// check for properties
-	var (
-		field reflect.Value
-		found bool
-	)
-
-	// check each field
-	tagName := g.tagName()
-	t := v.Type()
-	for i := 0; i < v.NumField(); i++ {
-		tag, ok := extractTagOrFieldName(t.Field(i), tagName)
-		if !ok {
-			continue
-		}
-
-		// check if the tag name matches the given name
-		if tag == name {
-			field = v.Field(i)
-			found = true
-			break
-		}
+	// check for properties, promoted and inlined ones included
+	f, ok, err := lookupField(v.Type(), g.tagName(), name)
+	if err != nil {
+		return nil, err
 	}
-
-	// return the field if found
-	if found && field.Kind() != reflect.Invalid {
-		return toValue(field, g.tag)
+	if !ok {
+		return nil, nil
 	}
-
-	// for not found
-	return nil, nil
+	field, err := v.FieldByIndexErr(f.index)
+	if err != nil {
+		// a field promoted from a nil embedded pointer
+		return starlark.None, nil
+	}
+	fv, err := toValue(field, g.tag, g.sc)
+	if err != nil {
+		return nil, err
+	}
+	// nor can scripts change a read-only field through its fields or
+	// elements
+	return freezeIf(fv, g.frozen || f.readonly), nil
 }
 
 // AttrNames returns the list of all fields and methods on this struct.
@@ -155,21 +147,22 @@ func (g *GoStruct) AttrNames() []string {
 	if v.Kind() != reflect.Ptr && v.CanAddr() {
 		mv = v.Addr()
 	}
-	count := mv.NumMethod()
+	sv := v
 	if v.Kind() == reflect.Ptr {
-		elem := v.Elem()
-		count += elem.NumField() + elem.NumMethod()
-	} else {
-		count += v.NumField()
+		sv = v.Elem()
 	}
-	names := make([]string, 0, count)
-
-	// get the defined tag name
-	tagName := g.tagName()
-	saveFieldName := func(f reflect.StructField) {
-		tag, ok := extractTagOrFieldName(f, tagName)
-		if ok {
-			names = append(names, tag)
+	fields := structFields(sv.Type(), g.tagName())
+	names := make([]string, 0, mv.NumMethod()+len(fields)+sv.NumMethod())
+
+	// fields tagged omitempty are listed only when set
+	saveFields := func() {
+		for _, f := range fields {
+			if f.omitempty {
+				if fv, err := sv.FieldByIndexErr(f.index); err != nil || fv.IsZero() {
+					continue
+				}
+			}
+			names = append(names, f.name)
 		}
 	}
 
@@ -177,18 +170,12 @@ func (g *GoStruct) AttrNames() []string {
 	for i := 0; i < mv.NumMethod(); i++ {
 		names = append(names, mv.Type().Method(i).Name)
 	}
+	saveFields()
 	if v.Kind() == reflect.Ptr {
 		t := v.Elem().Type()
-		for i := 0; i < t.NumField(); i++ {
-			saveFieldName(t.Field(i))
-		}
 		for i := 0; i < t.NumMethod(); i++ {
 			names = append(names, t.Method(i).Name)
 		}
-	} else {
-		for i := 0; i < v.NumField(); i++ {
-			saveFieldName(v.Type().Field(i))
-		}
 	}
 
 	// deduplicate names
@@ -213,37 +200,27 @@ func (g *GoStruct) SetField(name string, val starlark.Value) error {
 		v = v.Elem()
 	}
 
-	// check for properties
-	var (
-		field reflect.Value
-		found bool
-	)
-
-	// check each field to find the field
-	tagName := g.tagName()
-	t := v.Type()
-	for i := 0; i < v.NumField(); i++ {
-		tag, ok := extractTagOrFieldName(t.Field(i), tagName)
-		if !ok {
-			continue
-		}
-
-		// check if the tag name matches the given name
-		if tag == name {
-			field = v.Field(i)
-			found = true
-			break
-		}
+	f, ok, err := lookupField(v.Type(), g.tagName(), name)
+	if err != nil {
+		return err
 	}
-
-	// if not found
-	if !found {
+	if !ok {
 		return fmt.Errorf("field %s is not found", name)
 	}
+	if f.readonly {
+		return fmt.Errorf("field %s is read-only", name)
+	}
+	if !v.CanSet() {
+		return fmt.Errorf("%s is not a settable field", name)
+	}
+	field, err := fieldByIndexAlloc(v, f.index)
+	if err != nil {
+		return err
+	}
 
 	// try to set the field
 	if field.CanSet() {
-		val, err := tryConv(val, field.Type())
+		val, err := tryConv(val, field.Type(), g.sc)
 		if err != nil {
 			return err
 		}
@@ -271,9 +248,10 @@ func (g *GoStruct) Value() reflect.Value {
 }
 
 // Freeze marks this wrapper as frozen: writes through this GoStruct
-// (attribute or index assignment) fail afterwards. The freeze is shallow —
-// it does not propagate to the wrapped Go struct, which the host (or other
-// wrappers around the same value) can still mutate.
+// (attribute or index assignment) fail afterwards, and so do those through
+// the wrappers it returns for the fields. The freeze does not propagate to
+// the wrapped Go struct, which the host (or other wrappers around the same
+// value) can still mutate, nor stop its methods from changing it.
 func (g *GoStruct) Freeze() {
 	g.frozen = true
 }
@@ -330,3 +308,250 @@ func extractTagOrFieldName(f reflect.StructField, tagName string) (name string,
 	found = true
 	return
 }
+
+// structField is a field of a struct type exposed to scripts, as named and
+// configured by its struct tag, e.g. `starlark:"name,readonly,omitempty"`.
+// As in Go, the fields of embedded structs and pointers to structs are
+// promoted to the parent, unless the embedded field is renamed by its tag;
+// an embedded struct of an unexported type is not exposed itself, but its
+// fields are promoted likewise. The options are:
+//
+//   - readonly: scripts cannot set the field, and get it frozen (see
+//     GoStruct.Freeze), so they cannot change its fields or elements either;
+//   - omitempty: the field is left out of dir() (and so of json.encode)
+//     while it holds its zero value;
+//   - required: Decode fails if the script data lacks the field;
+//   - inline: the fields of the nested struct (or pointer to struct) are
+//     exposed as fields of the parent, as if it were embedded, and the
+//     nested struct itself is not.
+type structField struct {
+	name      string
+	index     []int // for FieldByIndex, longer than one for promoted fields
+	readonly  bool
+	omitempty bool
+	required  bool
+}
+
+type fieldsEntry struct {
+	fields    []structField
+	ambiguous map[string]bool
+	err       error // the problems with the tag options, see CheckStructTags
+}
+
+// fieldsCacheCap bounds each of the fieldsCaches; hosts wrap a small, fixed
+// set of struct types.
+const fieldsCacheCap = 4096
+
+// fieldsCaches memoizes typeFields, with a bounded cache per tag name.
+var fieldsCaches sync.Map // tag name -> *boundedTypeCache of fieldsEntry
+
+// fieldsCache returns the cache of typeFields for tagName.
+func fieldsCache(tagName string) *boundedTypeCache {
+	c, ok := fieldsCaches.Load(tagName)
+	if !ok {
+		c, _ = fieldsCaches.LoadOrStore(tagName, newBoundedTypeCache(fieldsCacheCap))
+	}
+	return c.(*boundedTypeCache)
+}
+
+// structFields returns the fields of the struct type t exposed under
+// tagName, promoted and inlined ones included, in declaration order.
+// Options that starlight does not understand are ignored.
+func structFields(t reflect.Type, tagName string) []structField {
+	return typeFields(t, tagName).fields
+}
+
+// typeFields computes and caches the fields of t for structFields. Go's
+// selector rules pick among fields of the same name: the shallowest one
+// wins, and several at the shallowest depth are ambiguous, so none of them
+// is exposed. The options of DefaultPropertyTag are checked; those of other
+// tags may belong to other packages, e.g. `json:"age,string"`.
+func typeFields(t reflect.Type, tagName string) fieldsEntry {
+	cache := fieldsCache(tagName)
+	if e, ok := cache.load(t); ok {
+		return e.(fieldsEntry)
+	}
+	check := tagName == DefaultPropertyTag
+	var (
+		fields []structField
+		bad    []string
+	)
+	var walk func(t reflect.Type, index []int, onPath map[reflect.Type]bool)
+	walk = func(t reflect.Type, index []int, onPath map[reflect.Type]bool) {
+		onPath[t] = true
+		defer delete(onPath, t)
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if sf.PkgPath != "" {
+				// an embedded struct of an unexported type is not exposed,
+				// but its exported fields are promoted
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous && ft.Kind() == reflect.Struct && !onPath[ft] && strings.SplitN(sf.Tag.Get(tagName), ",", 2)[0] == "" {
+					walk(ft, append(append([]int(nil), index...), i), onPath)
+				}
+				continue
+			}
+			name, ok := extractTagOrFieldName(sf, tagName)
+			if !ok {
+				continue
+			}
+			f := structField{name: name, index: append(append([]int(nil), index...), i)}
+			// embedded fields not renamed by their tag are promoted
+			inline := false
+			promote := sf.Anonymous && strings.SplitN(sf.Tag.Get(tagName), ",", 2)[0] == ""
+			for _, opt := range tagOptions(sf, tagName) {
+				switch opt {
+				case "readonly":
+					f.readonly = true
+				case "omitempty":
+					f.omitempty = true
+				case "required":
+					f.required = true
+				case "inline":
+					inline = true
+				default:
+					if check {
+						bad = append(bad, fmt.Sprintf("%s.%s: unknown tag option %q", t, sf.Name, opt))
+					}
+				}
+			}
+			ft := sf.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if inline && ft.Kind() != reflect.Struct {
+				// exposed as a plain field
+				if check {
+					bad = append(bad, fmt.Sprintf("%s.%s: cannot inline %s", t, sf.Name, sf.Type))
+				}
+				inline = false
+			}
+			if !inline {
+				fields = append(fields, f)
+			}
+			if (inline || promote && ft.Kind() == reflect.Struct) && !onPath[ft] {
+				walk(ft, f.index, onPath)
+			}
+		}
+	}
+	walk(t, nil, make(map[reflect.Type]bool))
+
+	// the shallowest field of each name wins; of several fields of the same
+	// struct tagged alike, the first declared
+	type level struct {
+		first     int
+		ambiguous bool
+	}
+	levels := make(map[string]*level, len(fields))
+	for i, f := range fields {
+		l, ok := levels[f.name]
+		switch {
+		case !ok:
+			levels[f.name] = &level{first: i}
+		case len(f.index) < len(fields[l.first].index):
+			*l = level{first: i}
+		case len(f.index) == len(fields[l.first].index) && !sameParent(f.index, fields[l.first].index):
+			l.ambiguous = true
+		}
+	}
+	e := fieldsEntry{fields: make([]structField, 0, len(fields))}
+	for i, f := range fields {
+		switch l := levels[f.name]; {
+		case l.ambiguous:
+			if e.ambiguous == nil {
+				e.ambiguous = make(map[string]bool)
+			}
+			e.ambiguous[f.name] = true
+		case l.first == i:
+			e.fields = append(e.fields, f)
+		}
+	}
+	if len(bad) > 0 {
+		e.err = errors.New(strings.Join(bad, "; "))
+	}
+	cache.store(t, e)
+	return e
+}
+
+// sameParent reports whether the field indexes a and b, of the same
+// length, are in the same struct.
+func sameParent(a, b []int) bool {
+	for i := 0; i < len(a)-1; i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// lookupField returns the exposed field of the struct type t named name,
+// or an error if several embedded structs provide it at the same depth.
+func lookupField(t reflect.Type, tagName, name string) (structField, bool, error) {
+	e := typeFields(t, tagName)
+	if e.ambiguous[name] {
+		return structField{}, false, fmt.Errorf("ambiguous field %s of %s", name, t)
+	}
+	for _, f := range e.fields {
+		if f.name == name {
+			return f, true, nil
+		}
+	}
+	return structField{}, false, nil
+}
+
+// tagOptions returns the options following the name in the tagName tag of
+// f.
+func tagOptions(f reflect.StructField, tagName string) []string {
+	if tagName == "" {
+		return nil
+	}
+	parts := strings.Split(f.Tag.Get(tagName), ",")
+	var opts []string
+	for _, p := range parts[1:] {
+		if p = strings.TrimSpace(p); p != "" {
+			opts = append(opts, p)
+		}
+	}
+	return opts
+}
+
+// fieldByIndexAlloc returns the field of the settable struct v at index,
+// allocating the nil embedded pointers on the way.
+func fieldByIndexAlloc(v reflect.Value, index []int) (reflect.Value, error) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !v.CanSet() {
+					return reflect.Value{}, fmt.Errorf("cannot set a field of nil %s", v.Type())
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, nil
+}
+
+// CheckStructTags reports the struct tag options under tagName (or
+// DefaultPropertyTag if empty) of the struct, or pointer to struct, v that
+// starlight does not understand. Wrapped structs and Decode ignore such
+// options, so hosts may call it, e.g. in tests, to catch typos. Only the
+// options of DefaultPropertyTag are checked: other tags, such as json, may
+// carry options meant for other packages.
+func CheckStructTags(v interface{}, tagName string) error {
+	t := reflect.TypeOf(v)
+	if t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return fmt.Errorf("value must be a struct or pointer to a struct, but was %T", v)
+	}
+	if tagName == "" {
+		tagName = DefaultPropertyTag
+	}
+	return typeFields(t, tagName).err
+}
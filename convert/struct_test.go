@@ -17,14 +17,14 @@ import (
 type nested struct {
 	Truth  bool
 	Name   string  `star:"name"`
-	Number int     `star:"num,omitempty,nil"`
+	Number int     `star:"num,omitempty"`
 	Value  float64 `star:"-"`
 }
 
 type mega struct {
 	Bool     bool
 	Int      int
-	Int64    int64 `star:"hate,omitempty,null"`
+	Int64    int64 `star:"hate,omitempty"`
 	Body     io.Reader
 	String   string `star:"love"`
 	Map      map[string]string
@@ -649,7 +649,7 @@ assert.Eq(dir(a), ["address", "name"])
 assert.Eq(a.name, "bob")
 assert.Eq(a.address, "")
 assert.Eq(type(a), "starlight_struct<*convert_test.contact>")
-assert.Eq(dir(a), ["address", "name"])
+assert.Eq(dir(a), ["name"])
 `,
 		},
 	}
@@ -687,3 +687,263 @@ assert.Eq(dir(a), ["address", "name"])
 		})
 	}
 }
+
+type tagAddress struct {
+	City string `starlark:"city"`
+	Zip  string `starlark:"zip,omitempty"`
+}
+
+type tagAccount struct {
+	ID      int    `starlark:"id,readonly,required"`
+	Owner   string `starlark:"owner,required"`
+	Note    string `starlark:"note,omitempty"`
+	Home    tagAddress
+	Billing *tagAddress `starlark:",inline"`
+}
+
+// TestStructTagOptions verifies the readonly, omitempty, required and inline
+// tag options are honored.
+func TestStructTagOptions(t *testing.T) {
+	acct := &tagAccount{ID: 7, Owner: "ann"}
+	s := convert.NewStructWithTag(acct, "starlark")
+
+	if got, want := fmt.Sprint(s.AttrNames()), "[id owner Home city]"; got != want {
+		t.Errorf("AttrNames() = %s, want %s", got, want)
+	}
+	if v, err := s.Attr("city"); err != nil || v != starlark.None {
+		t.Errorf("Attr(city) of nil inline pointer = %v, %v; want None", v, err)
+	}
+	if err := s.SetField("id", starlark.MakeInt(8)); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("SetField(id) = %v, want read-only error", err)
+	}
+	if err := s.SetField("city", starlark.String("Oslo")); err != nil {
+		t.Fatal(err)
+	}
+	if acct.Billing == nil || acct.Billing.City != "Oslo" {
+		t.Errorf("SetField(city) did not fill the inline struct: %+v", acct.Billing)
+	}
+	acct.Note = "vip"
+	if got, want := fmt.Sprint(s.AttrNames()), "[id owner note Home city]"; got != want {
+		t.Errorf("AttrNames() = %s, want %s", got, want)
+	}
+
+	var out tagAccount
+	v := evalValue(t, `{"id": 1, "owner": "bo", "city": "Rome", "zip": "00100"}`)
+	if err := convert.Decode(v, &out, convert.DecodeTag("starlark")); err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != 1 || out.Billing == nil || out.Billing.Zip != "00100" {
+		t.Errorf("Decode() = %+v", out)
+	}
+	err := convert.Decode(evalValue(t, `{"id": 1}`), &out, convert.DecodeTag("starlark"))
+	if err == nil || !strings.Contains(err.Error(), "owner") || !strings.Contains(err.Error(), "required") {
+		t.Errorf("Decode() without owner = %v, want missing required field error", err)
+	}
+
+	type badTags struct {
+		A int `starlark:"a,readonyl"`
+		B int `starlark:"b,omitempty,null"`
+	}
+	err = convert.CheckStructTags(badTags{}, "starlark")
+	if err == nil || !strings.Contains(err.Error(), "readonyl") || !strings.Contains(err.Error(), "null") {
+		t.Errorf("CheckStructTags() = %v, want both unknown options reported", err)
+	}
+	if err := convert.CheckStructTags(&tagAccount{}, "starlark"); err != nil {
+		t.Errorf("CheckStructTags() = %v", err)
+	}
+
+	// wrapped, such a struct ignores them
+	bad := convert.NewStruct(&badTags{A: 1})
+	if v, err := bad.Attr("a"); err != nil || v != starlark.MakeInt(1) {
+		t.Errorf("Attr() = %v, %v; want 1", v, err)
+	}
+	if err := bad.SetField("b", starlark.MakeInt(2)); err != nil {
+		t.Errorf("SetField() = %v", err)
+	}
+
+	// the options of other tags are left to their packages
+	type jsonTags struct {
+		Name string `json:"name"`
+		Age  int    `json:"age,string"`
+	}
+	if err := convert.CheckStructTags(jsonTags{}, "json"); err != nil {
+		t.Errorf("CheckStructTags(json) = %v", err)
+	}
+	jv, err := convert.ToValueWithTag(&jsonTags{Name: "ann", Age: 30}, "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := jv.(*convert.GoStruct).Attr("age"); err != nil || v != starlark.MakeInt(30) {
+		t.Errorf("Attr(age) = %v, %v; want 30", v, err)
+	}
+	func() {
+		defer func() {
+			if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "readonyl") {
+				t.Errorf("Params() with a bad options struct = %v, want a panic", r)
+			}
+		}()
+		convert.Func(func(n int, o badTags) {}).Params("n")
+	}()
+}
+
+type lockedConfig struct {
+	Inner  tagAddress     `starlark:"inner,readonly"`
+	Tags   []string       `starlark:"tags,readonly"`
+	Homes  []tagAddress   `starlark:"homes,readonly"`
+	Limits map[string]int `starlark:"limits,readonly"`
+	Open   []string       `starlark:"open"`
+}
+
+// TestStructReadonlyNested verifies scripts cannot change a read-only field
+// through its fields or elements, while they can change writable ones.
+func TestStructReadonlyNested(t *testing.T) {
+	cfg := &lockedConfig{
+		Inner:  tagAddress{City: "Oslo"},
+		Tags:   []string{"a"},
+		Homes:  []tagAddress{{City: "Rome"}},
+		Limits: map[string]int{"cpu": 1},
+		Open:   []string{"a"},
+	}
+	globals := starlark.StringDict{"r": convert.NewStruct(cfg)}
+	for script, want := range map[string]string{
+		`r.inner.city = "Bern"`:    "frozen struct",
+		`r.tags[0] = "z"`:          "frozen slice",
+		`r.tags.append("z")`:       "frozen slice",
+		`r.homes[0].city = "Bern"`: "frozen struct",
+		`r.limits["cpu"] = 9`:      "frozen map",
+	} {
+		if _, err := execWithThread(&starlark.Thread{}, script, globals); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s = %v, want an error containing %q", script, err, want)
+		}
+	}
+	if cfg.Inner.City != "Oslo" || cfg.Tags[0] != "a" || len(cfg.Tags) != 1 || cfg.Homes[0].City != "Rome" || cfg.Limits["cpu"] != 1 {
+		t.Errorf("read-only fields changed: %+v", cfg)
+	}
+
+	if _, err := execWithThread(&starlark.Thread{}, `r.open[0] = "z"`, globals); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Open[0] != "z" {
+		t.Errorf("open = %v, want [z]", cfg.Open)
+	}
+}
+
+type BaseModel struct {
+	ID   int    `starlark:"id"`
+	Kind string `starlark:"kind"`
+}
+
+type Audit struct {
+	By   string `starlark:"by"`
+	Kind string `starlark:"kind"`
+}
+
+type Stamp struct {
+	By string `starlark:"by"`
+}
+
+type invoice struct {
+	BaseModel
+	*Audit
+	Stamp
+	Kind  string    `starlark:"kind"`
+	Other BaseModel `starlark:"other"`
+}
+
+type orderBase struct {
+	ID int `starlark:"id"`
+}
+
+type order2 struct {
+	orderBase
+	*orderAudit
+	note string
+	Memo string `starlark:"memo"`
+}
+
+type orderAudit struct {
+	By string `starlark:"by"`
+}
+
+// TestStructPromotedFields verifies the fields of embedded structs are
+// promoted as in Go.
+func TestStructPromotedFields(t *testing.T) {
+	inv := &invoice{BaseModel: BaseModel{ID: 3}, Kind: "net"}
+	s := convert.NewStructWithTag(inv, "starlark")
+
+	if got, want := fmt.Sprint(s.AttrNames()), "[BaseModel id Audit Stamp kind other]"; got != want {
+		t.Errorf("AttrNames() = %s, want %s", got, want)
+	}
+	if v, err := s.Attr("id"); err != nil || v != starlark.MakeInt(3) {
+		t.Errorf("Attr(id) = %v, %v; want 3", v, err)
+	}
+	if v, err := s.Attr("kind"); err != nil || v != starlark.String("net") {
+		t.Errorf("Attr(kind) = %v, %v; want the shadowing field", v, err)
+	}
+	if v, err := s.Attr("Audit"); err != nil || v.Truth() {
+		t.Errorf("Attr(Audit) = %v, %v; want the nil embedded pointer", v, err)
+	}
+	if _, err := s.Attr("by"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("Attr(by) = %v, want ambiguous field error", err)
+	}
+	if err := s.SetField("by", starlark.String("x")); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("SetField(by) = %v, want ambiguous field error", err)
+	}
+	if err := s.SetField("id", starlark.MakeInt(4)); err != nil || inv.ID != 4 {
+		t.Errorf("SetField(id) = %v, ID = %d", err, inv.ID)
+	}
+
+	type audited struct {
+		*Audit `starlark:",readonly"`
+	}
+	a := &audited{}
+	s = convert.NewStructWithTag(a, "starlark")
+	if v, err := s.Attr("by"); err != nil || v != starlark.None {
+		t.Errorf("Attr(by) of nil embedded pointer = %v, %v; want None", v, err)
+	}
+	if err := s.SetField("by", starlark.String("ann")); err != nil {
+		t.Fatal(err)
+	}
+	if a.Audit == nil || a.By != "ann" {
+		t.Errorf("SetField(by) did not allocate the embedded struct: %+v", a.Audit)
+	}
+
+	// the fields of embedded unexported structs are promoted, unlike the
+	// other unexported fields
+	o := &order2{orderBase: orderBase{ID: 7}, note: "n"}
+	s = convert.NewStructWithTag(o, "starlark")
+	if got, want := fmt.Sprint(s.AttrNames()), "[id by memo]"; got != want {
+		t.Errorf("AttrNames() = %s, want %s", got, want)
+	}
+	if v, err := s.Attr("id"); err != nil || v != starlark.MakeInt(7) {
+		t.Errorf("Attr(id) = %v, %v; want 7", v, err)
+	}
+	if v, err := s.Attr("by"); err != nil || v != starlark.None {
+		t.Errorf("Attr(by) of nil embedded pointer = %v, %v; want None", v, err)
+	}
+	if v, err := s.Attr("note"); err != nil || v != nil {
+		t.Errorf("Attr(note) = %v, %v; want no such field", v, err)
+	}
+	if err := s.SetField("id", starlark.MakeInt(8)); err != nil || o.ID != 8 {
+		t.Errorf("SetField(id) = %v, ID = %d", err, o.ID)
+	}
+	if err := s.SetField("by", starlark.String("x")); err == nil {
+		t.Error("SetField(by) allocated an unexported embedded pointer")
+	}
+	var decoded order2
+	if err := convert.Decode(evalValue(t, `{"id": 5}`), &decoded, convert.DecodeTag("starlark")); err != nil || decoded.ID != 5 {
+		t.Errorf("Decode() = %+v, %v", decoded, err)
+	}
+	if err := convert.Decode(evalValue(t, `{"by": "x"}`), &decoded, convert.DecodeTag("starlark")); err == nil {
+		t.Error("Decode() allocated an unexported embedded pointer")
+	}
+
+	var out invoice
+	v := evalValue(t, `{"id": 9, "kind": "gross", "other": {"id": 1}}`)
+	if err := convert.Decode(v, &out, convert.DecodeTag("starlark")); err != nil {
+		t.Fatal(err)
+	}
+	if out.ID != 9 || out.Kind != "gross" || out.BaseModel.Kind != "" || out.Other.ID != 1 {
+		t.Errorf("Decode() = %+v", out)
+	}
+}
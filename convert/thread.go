@@ -0,0 +1,68 @@
+package convert
+
+import (
+	"context"
+	"reflect"
+
+	"go.starlark.net/starlark"
+)
+
+// ContextKey is the thread-local key under which the host stores the
+// context.Context of a Starlark run (see starlight.WithContext). Wrapped Go
+// functions whose first parameter is a context.Context receive it.
+const ContextKey = "starlight.context"
+
+// ThreadContext returns the context.Context attached to the thread under
+// ContextKey, or context.Background() if there is none (or thread is nil).
+func ThreadContext(thread *starlark.Thread) context.Context {
+	if thread != nil {
+		if ctx, ok := thread.Local(ContextKey).(context.Context); ok && ctx != nil {
+			return ctx
+		}
+	}
+	return context.Background()
+}
+
+// BindThread returns v bound to thread, the main thread of a run v is given
+// to: if v is a GoChan, a copy whose for loops stop waiting once the
+// context of thread (see ThreadContext) is done, cancelling thread so that
+// the run fails with that context error. Other values, Go wrappers
+// included, are returned as they are: the channels reached through their
+// fields, elements or methods are not bound. Starlark iterators do not see
+// the thread running the loop, so this is how a loop over a channel ends
+// with its run; starlight binds the globals of its runs this way, and the
+// values wrapped by Go functions called on a thread are bound to it.
+func BindThread(v starlark.Value, thread *starlark.Thread) starlark.Value {
+	if g, ok := v.(*GoChan); ok {
+		return &GoChan{v: g.v, tag: g.tag, sc: g.sc.withThread(thread), ctx: g.ctx}
+	}
+	return v
+}
+
+// numHostArgs reports how many leading parameters of the Go function type t
+// are supplied by the host rather than by the script: a first parameter of
+// type *starlark.Thread receives the calling thread, and a first parameter
+// of type context.Context receives ThreadContext of that thread.
+func numHostArgs(t reflect.Type) int {
+	if t.NumIn() == 0 {
+		return 0
+	}
+	if in := t.In(0); in == threadType || in == contextType {
+		// a variadic func whose only parameter is the variadic slice never
+		// matches here: its In(0) is a slice type
+		return 1
+	}
+	return 0
+}
+
+// appendHostArgs appends the host-supplied leading arguments for the Go
+// function type t (see numHostArgs) to rvs.
+func appendHostArgs(rvs []reflect.Value, t reflect.Type, thread *starlark.Thread) []reflect.Value {
+	if numHostArgs(t) == 0 {
+		return rvs
+	}
+	if t.In(0) == threadType {
+		return append(rvs, reflect.ValueOf(thread))
+	}
+	return append(rvs, reflect.ValueOf(ThreadContext(thread)))
+}
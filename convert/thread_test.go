@@ -0,0 +1,103 @@
+package convert_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+type ctxKey struct{}
+
+// execWithThread runs script on the given thread with envs as predeclared
+// values.
+func execWithThread(thread *starlark.Thread, script string, envs starlark.StringDict) (starlark.StringDict, error) {
+	return starlark.ExecFileOptions(testFileOptions, thread, "thread.star", script, envs)
+}
+
+// TestHostThreadArg verifies a leading *starlark.Thread parameter receives
+// the calling thread instead of a script argument.
+func TestHostThreadArg(t *testing.T) {
+	thread := &starlark.Thread{Name: "t1"}
+	thread.SetLocal("tenant", "acme")
+	fn := convert.MakeStarFn("tenant", func(th *starlark.Thread, suffix string) string {
+		return th.Local("tenant").(string) + suffix
+	})
+	res, err := execWithThread(thread, `out = tenant("-1")`, starlark.StringDict{"tenant": fn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res["out"]; got != starlark.String("acme-1") {
+		t.Fatalf("out = %v, want acme-1", got)
+	}
+
+	// the injected parameter does not count towards the script arity
+	_, err = execWithThread(thread, `tenant()`, starlark.StringDict{"tenant": fn})
+	if err == nil || !strings.Contains(err.Error(), "expected 1 args but got 0") {
+		t.Fatalf("expected arity error, got %v", err)
+	}
+}
+
+// TestHostContextArg verifies a leading context.Context parameter receives
+// the context stored under convert.ContextKey, or context.Background().
+func TestHostContextArg(t *testing.T) {
+	fn := convert.MakeStarFn("reqid", func(ctx context.Context) string {
+		if v, ok := ctx.Value(ctxKey{}).(string); ok {
+			return v
+		}
+		return "none"
+	})
+
+	thread := &starlark.Thread{}
+	thread.SetLocal(convert.ContextKey, context.WithValue(context.Background(), ctxKey{}, "req-42"))
+	res, err := execWithThread(thread, `out = reqid()`, starlark.StringDict{"reqid": fn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res["out"]; got != starlark.String("req-42") {
+		t.Fatalf("out = %v, want req-42", got)
+	}
+
+	res, err = execWithThread(&starlark.Thread{}, `out = reqid()`, starlark.StringDict{"reqid": fn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res["out"]; got != starlark.String("none") {
+		t.Fatalf("out without context = %v, want none", got)
+	}
+}
+
+// TestHostArgVariadic verifies host injection for variadic functions.
+func TestHostArgVariadic(t *testing.T) {
+	fn := convert.MakeStarFn("join", func(ctx context.Context, sep string, parts ...string) string {
+		return ctx.Value(ctxKey{}).(string) + ":" + strings.Join(parts, sep)
+	})
+	thread := &starlark.Thread{}
+	thread.SetLocal(convert.ContextKey, context.WithValue(context.Background(), ctxKey{}, "p"))
+	res, err := execWithThread(thread, `out = join("-", "a", "b")`, starlark.StringDict{"join": fn})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res["out"]; got != starlark.String("p:a-b") {
+		t.Fatalf("out = %v, want p:a-b", got)
+	}
+	_, err = execWithThread(thread, `join()`, starlark.StringDict{"join": fn})
+	if err == nil || !strings.Contains(err.Error(), "expected at least 1 args but got 0") {
+		t.Fatalf("expected arity error, got %v", err)
+	}
+}
+
+// TestThreadContextFallback verifies ThreadContext on a nil thread and on a
+// thread with a non-context local.
+func TestThreadContextFallback(t *testing.T) {
+	if convert.ThreadContext(nil) != context.Background() {
+		t.Fatal("expected context.Background() for a nil thread")
+	}
+	thread := &starlark.Thread{}
+	thread.SetLocal(convert.ContextKey, "not a context")
+	if convert.ThreadContext(thread) != context.Background() {
+		t.Fatal("expected context.Background() for a non-context local")
+	}
+}
@@ -0,0 +1,71 @@
+package convert
+
+import (
+	"time"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// TracerKey is the thread-local key under which the host stores the *Tracer
+// of a Starlark run (see starlight.WithTracer). Calls of wrapped Go
+// functions made on a thread carrying a Tracer are reported to it.
+const TracerKey = "starlight.tracer"
+
+// Tracer receives a CallTrace for every call of a wrapped Go function (see
+// MakeStarFn) made on a thread that carries it under TracerKey. The bound
+// functions themselves are not changed, so tracing can be switched on for a
+// single run.
+type Tracer struct {
+	// OnCall is called once the Go function has returned, on the calling
+	// goroutine. It must not retain call.Args beyond the call if the
+	// arguments may be mutated by the script.
+	OnCall func(thread *starlark.Thread, call *CallTrace)
+	// Redact, if set, is called with every argument before it is reported
+	// and returns the value to report instead, e.g. to hide secrets.
+	Redact func(fn string, index int, arg interface{}) interface{}
+}
+
+// CallTrace describes one call of a wrapped Go function from a script.
+type CallTrace struct {
+	Name     string          // the name the function is bound under
+	Position syntax.Position // the position of the call in the script
+	Args     []interface{}   // the arguments, converted as by FromValue and redacted
+	Result   interface{}     // the result, converted as by FromValue; nil if Err is set
+	Err      error           // the error the call returned or raised, if any
+	Duration time.Duration   // the time spent in the call, conversions included
+}
+
+// threadTracer returns the Tracer attached to thread, or nil.
+func threadTracer(thread *starlark.Thread) *Tracer {
+	if thread == nil {
+		return nil
+	}
+	if t, ok := thread.Local(TracerKey).(*Tracer); ok && t != nil && t.OnCall != nil {
+		return t
+	}
+	return nil
+}
+
+// report builds the CallTrace of a finished call and passes it to OnCall.
+func (t *Tracer) report(thread *starlark.Thread, name string, args starlark.Tuple, start time.Time, ret starlark.Value, err error) {
+	call := &CallTrace{
+		Name:     name,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	// frame 0 is the builtin itself, frame 1 the Starlark caller
+	if thread.CallStackDepth() > 1 {
+		call.Position = thread.CallFrame(1).Pos
+	}
+	call.Args = FromTuple(args)
+	if t.Redact != nil {
+		for i, a := range call.Args {
+			call.Args[i] = t.Redact(name, i, a)
+		}
+	}
+	if err == nil && ret != nil {
+		call.Result = FromValue(ret)
+	}
+	t.OnCall(thread, call)
+}
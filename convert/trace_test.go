@@ -0,0 +1,87 @@
+package convert_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+// TestTracer verifies calls of wrapped Go functions are reported with their
+// position, redacted arguments, result and error, and only on threads that
+// carry a Tracer.
+func TestTracer(t *testing.T) {
+	var calls []*convert.CallTrace
+	tracer := &convert.Tracer{
+		OnCall: func(_ *starlark.Thread, call *convert.CallTrace) { calls = append(calls, call) },
+		Redact: func(fn string, i int, arg interface{}) interface{} {
+			if fn == "login" && i == 1 {
+				return "***"
+			}
+			return arg
+		},
+	}
+	envs := starlark.StringDict{
+		"login": convert.MakeStarFn("login", func(user, password string) string { return "token-" + user }),
+		"sum": convert.MakeStarFn("sum", func(vals ...int) int {
+			n := 0
+			for _, v := range vals {
+				n += v
+			}
+			return n
+		}),
+		"fail": convert.MakeStarFn("fail", func() error { return errors.New("denied") }),
+		"boom": convert.MakeStarFn("boom", func() { panic("bang") }),
+	}
+	script := `
+tok = login("bob", "hunter2")
+n = sum(1, 2, 3)
+`
+	thread := &starlark.Thread{}
+	thread.SetLocal(convert.TracerKey, tracer)
+	_, err := execWithThread(thread, script, envs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("got %d calls, want 2", len(calls))
+	}
+	c := calls[0]
+	if c.Name != "login" || c.Position.String() != "thread.star:2:12" {
+		t.Errorf("call 0 = %s at %s", c.Name, c.Position)
+	}
+	if len(c.Args) != 2 || c.Args[0] != "bob" || c.Args[1] != "***" {
+		t.Errorf("call 0 args = %v", c.Args)
+	}
+	if c.Result != "token-bob" || c.Err != nil || c.Duration <= 0 {
+		t.Errorf("call 0 = %v, %v, %v", c.Result, c.Err, c.Duration)
+	}
+	if c := calls[1]; c.Name != "sum" || len(c.Args) != 3 || c.Result != int64(6) {
+		t.Errorf("call 1 = %s %v -> %v", c.Name, c.Args, c.Result)
+	}
+
+	// errors and panics are reported too
+	for _, name := range []string{"fail", "boom"} {
+		calls = nil
+		if _, err := starlark.Call(thread, envs[name], nil, nil); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+		if len(calls) != 1 || calls[0].Err == nil || calls[0].Result != nil {
+			t.Fatalf("%s: calls = %v", name, calls)
+		}
+	}
+	if !strings.Contains(calls[0].Err.Error(), "panic in func boom") {
+		t.Errorf("boom error = %v", calls[0].Err)
+	}
+
+	// no tracer, no report
+	calls = nil
+	if _, err := execWithThread(&starlark.Thread{}, script, envs); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 0 {
+		t.Fatalf("untraced thread reported %d calls", len(calls))
+	}
+}
@@ -0,0 +1,260 @@
+package convert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"go.starlark.net/starlark"
+)
+
+// TypesKey is the thread-local key under which the host may store a
+// *TypeRegistry for a Starlark run (see starlight.WithTypes). Wrapped Go
+// functions called on the thread consult its conversions before the global
+// ones (see TypeRegistry).
+const TypesKey = "starlight.types"
+
+// typeConverter is the pair of conversions registered for a Go type.
+type typeConverter struct {
+	to   func(reflect.Value) (starlark.Value, error)
+	from func(starlark.Value) (reflect.Value, error)
+}
+
+// TypeRegistry holds conversions of Go types registered by the host (see
+// RegisterType). The package-level RegisterType fills the global registry,
+// which every conversion consults. A TypeRegistry made by NewTypeRegistry
+// scopes its conversions, which are consulted before the global ones, to:
+//
+//   - the values converted by its ToValue and MakeStringDict methods, and
+//     decoded with DecodeTypes;
+//   - the calls of wrapped Go functions on a thread holding it under
+//     TypesKey, for their arguments and results;
+//   - the fields, elements and methods of the Go values wrapped by either.
+//
+// Hosts give the scripts of one Cache conversions of their own this way,
+// leaving other scripts of the process unaffected. A TypeRegistry is safe
+// for concurrent use.
+type TypeRegistry struct {
+	mu         sync.RWMutex
+	converters map[reflect.Type]typeConverter
+	n          int32 // len(converters), accessed atomically to skip lookups while zero
+}
+
+// globalTypes is the registry of RegisterType.
+var globalTypes TypeRegistry
+
+// NewTypeRegistry returns an empty registry.
+func NewTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{}
+}
+
+// RegisterType registers the conversions of the Go type t, e.g. a decimal,
+// UUID or money type, which take precedence over the built-in ones:
+//
+//   - toStarlark converts values of type t wherever Go values are converted
+//     to Starlark (ToValue, MakeStringDict, fields and elements of wrapped
+//     values, results of wrapped functions);
+//   - fromStarlark converts Starlark values, None included, to t wherever
+//     values of type t are expected (arguments of wrapped functions, struct
+//     fields, slice and map elements, Decode). Its result must be
+//     assignable to t.
+//
+// Either may be nil to keep the built-in conversion for that direction;
+// registering nil for both removes the conversions of t. Converters are
+// shared by every script of the process, and scripts cannot register any,
+// so they are meant to be registered by the host before converting values,
+// typically in an init function: collections of t that the built-in
+// conversions reject are only accepted once t is registered. To give the
+// scripts of one run, or one Cache, conversions of their own, register them
+// with a TypeRegistry instead.
+func RegisterType(t reflect.Type, toStarlark func(reflect.Value) (starlark.Value, error), fromStarlark func(starlark.Value) (reflect.Value, error)) {
+	globalTypes.RegisterType(t, toStarlark, fromStarlark)
+}
+
+// RegisterType registers the conversions of the Go type t in r, as the
+// package-level RegisterType does globally. They take precedence over the
+// global ones wherever r applies.
+func (r *TypeRegistry) RegisterType(t reflect.Type, toStarlark func(reflect.Value) (starlark.Value, error), fromStarlark func(starlark.Value) (reflect.Value, error)) {
+	if t == nil {
+		panic(errors.New("convert: RegisterType of nil type"))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if toStarlark == nil && fromStarlark == nil {
+		delete(r.converters, t)
+	} else {
+		if r.converters == nil {
+			r.converters = make(map[reflect.Type]typeConverter)
+		}
+		r.converters[t] = typeConverter{toStarlark, fromStarlark}
+	}
+	atomic.StoreInt32(&r.n, int32(len(r.converters)))
+}
+
+// ToValue converts v as the package-level ToValue does, consulting the
+// conversions of r first.
+func (r *TypeRegistry) ToValue(v interface{}) (starlark.Value, error) {
+	return convertValue(v, emptyStr, r.scope())
+}
+
+// MakeStringDict makes a StringDict from m as the package-level
+// MakeStringDict does, consulting the conversions of r first.
+func (r *TypeRegistry) MakeStringDict(m map[string]interface{}) (starlark.StringDict, error) {
+	return makeStringDictTag(m, emptyStr, r.scope())
+}
+
+// scope returns the scope of the values converted with r.
+func (r *TypeRegistry) scope() *scope {
+	if r == nil {
+		return nil
+	}
+	return &scope{types: r}
+}
+
+// lookup returns the converters registered in r for t, if any.
+func (r *TypeRegistry) lookup(t reflect.Type) (typeConverter, bool) {
+	if r == nil || atomic.LoadInt32(&r.n) == 0 {
+		return typeConverter{}, false
+	}
+	r.mu.RLock()
+	c, ok := r.converters[t]
+	r.mu.RUnlock()
+	return c, ok
+}
+
+// scope is what the Go values converted together share: the registry
+// consulted before the global one, and the thread of the run they were
+// converted for (see BindThread). The values wrapped in a scope pass it on
+// to the values they convert; a nil scope consults the global registry
+// only, for no thread.
+type scope struct {
+	types  *TypeRegistry
+	thread *starlark.Thread
+}
+
+// callScope returns the scope of a call on thread of a function wrapped in
+// sc: the thread, with its registry if it has one, and the registry of sc
+// otherwise.
+func callScope(thread *starlark.Thread, sc *scope) *scope {
+	if thread == nil {
+		return sc
+	}
+	r, _ := thread.Local(TypesKey).(*TypeRegistry)
+	if r == nil && sc != nil {
+		r = sc.types
+	}
+	if sc != nil && sc.thread == thread && sc.types == r {
+		return sc
+	}
+	return &scope{types: r, thread: thread}
+}
+
+// withThread returns sc bound to thread.
+func (sc *scope) withThread(thread *starlark.Thread) *scope {
+	if sc == nil {
+		return &scope{thread: thread}
+	}
+	return &scope{types: sc.types, thread: thread}
+}
+
+// boundThread returns the thread of sc, or nil.
+func (sc *scope) boundThread() *starlark.Thread {
+	if sc == nil {
+		return nil
+	}
+	return sc.thread
+}
+
+// runContext returns the context of the thread of sc (see ThreadContext),
+// or nil if it has none.
+func (sc *scope) runContext() context.Context {
+	if sc == nil || sc.thread == nil {
+		return nil
+	}
+	ctx, _ := sc.thread.Local(ContextKey).(context.Context)
+	return ctx
+}
+
+// registeredType returns the converters for t in the registry of sc, or
+// else in the global one.
+func (sc *scope) registeredType(t reflect.Type) (typeConverter, bool) {
+	if sc != nil {
+		if c, ok := sc.types.lookup(t); ok {
+			return c, true
+		}
+	}
+	return globalTypes.lookup(t)
+}
+
+// toRegistered converts val with the toStarlark converter registered for
+// its type, if any.
+func (sc *scope) toRegistered(val reflect.Value) (starlark.Value, bool, error) {
+	c, ok := sc.registeredType(val.Type())
+	if !ok || c.to == nil {
+		return nil, false, nil
+	}
+	sv, err := c.to(val)
+	if err == nil && sv == nil {
+		sv = starlark.None
+	}
+	return sv, true, err
+}
+
+// fromRegistered converts v with the fromStarlark converter registered for
+// t, if any.
+func (sc *scope) fromRegistered(v starlark.Value, t reflect.Type) (reflect.Value, bool, error) {
+	c, ok := sc.registeredType(t)
+	if !ok || c.from == nil {
+		return reflect.Value{}, false, nil
+	}
+	rv, err := c.from(v)
+	if err != nil {
+		return reflect.Value{}, true, err
+	}
+	if !rv.IsValid() || !rv.Type().AssignableTo(t) {
+		return reflect.Value{}, true, fmt.Errorf("converter of %s returned %s", t, typeString(rv))
+	}
+	if rv.Type() != t {
+		rv = rv.Convert(t)
+	}
+	return rv, true, nil
+}
+
+// fromRegisteredElem converts elem, an element of a collection as
+// FromValue returns it, with the fromStarlark converter registered for t,
+// if any. The converter gets the Starlark form of elem; an element of type
+// t is kept as it is.
+func (sc *scope) fromRegisteredElem(elem reflect.Value, t reflect.Type) (reflect.Value, bool, error) {
+	c, ok := sc.registeredType(t)
+	if !ok || c.from == nil {
+		return reflect.Value{}, false, nil
+	}
+	if elem.Kind() == reflect.Interface {
+		if elem.IsNil() {
+			return sc.fromRegistered(starlark.None, t)
+		}
+		elem = elem.Elem()
+	}
+	if elem.Type().AssignableTo(t) {
+		return elem, true, nil
+	}
+	sv, ok := elem.Interface().(starlark.Value)
+	if !ok {
+		var err error
+		if sv, err = toValue(elem, emptyStr, nil); err != nil {
+			return reflect.Value{}, true, err
+		}
+	}
+	return sc.fromRegistered(sv, t)
+}
+
+// typeString describes the type of rv for error messages.
+func typeString(rv reflect.Value) string {
+	if !rv.IsValid() {
+		return "an invalid value"
+	}
+	return rv.Type().String()
+}
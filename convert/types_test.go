@@ -0,0 +1,243 @@
+package convert_test
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+type money struct {
+	cents    int64
+	currency string
+}
+
+type handle uintptr
+
+type ledgerEntry struct {
+	Memo   string `starlark:"memo"`
+	Amount money  `starlark:"amount"`
+}
+
+func init() {
+	convert.RegisterType(reflect.TypeOf(money{}),
+		func(v reflect.Value) (starlark.Value, error) {
+			m := v.Interface().(money)
+			return starlark.String(fmt.Sprintf("%d.%02d %s", m.cents/100, m.cents%100, m.currency)), nil
+		},
+		func(v starlark.Value) (reflect.Value, error) {
+			s, ok := starlark.AsString(v)
+			if !ok {
+				return reflect.Value{}, fmt.Errorf("want a money string, got %s", v.Type())
+			}
+			var units, cents int64
+			var cur string
+			if _, err := fmt.Sscanf(s, "%d.%d %s", &units, &cents, &cur); err != nil {
+				return reflect.Value{}, fmt.Errorf("bad money %q", s)
+			}
+			return reflect.ValueOf(money{units*100 + cents, cur}), nil
+		})
+	convert.RegisterType(reflect.TypeOf(handle(0)),
+		func(v reflect.Value) (starlark.Value, error) {
+			return starlark.MakeUint64(uint64(v.Uint())), nil
+		}, nil)
+}
+
+// TestRegisterType verifies registered conversions take precedence in both
+// directions.
+func TestRegisterType(t *testing.T) {
+	entry := &ledgerEntry{Memo: "rent", Amount: money{125050, "EUR"}}
+	double := func(m money) money {
+		return money{m.cents * 2, m.currency}
+	}
+	globals, err := convert.MakeStringDictWithTag(map[string]interface{}{
+		"entry":   entry,
+		"double":  double,
+		"handles": []handle{3, 4},
+	}, "starlark")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := execWithThread(&starlark.Thread{}, `
+amount = entry.amount
+doubled = double("1.50 USD")
+entry.amount = "2.25 USD"
+total = handles[0] + handles[1]
+`, globals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res["amount"]; got != starlark.String("1250.50 EUR") {
+		t.Errorf("amount = %v", got)
+	}
+	if got := res["doubled"]; got != starlark.String("3.00 USD") {
+		t.Errorf("doubled = %v", got)
+	}
+	if entry.Amount != (money{225, "USD"}) {
+		t.Errorf("entry.Amount = %+v", entry.Amount)
+	}
+	if got := res["total"]; got != starlark.MakeInt(7) {
+		t.Errorf("total = %v", got)
+	}
+
+	_, err = execWithThread(&starlark.Thread{}, `double(12)`, globals)
+	if err == nil || !strings.Contains(err.Error(), "want a money string") {
+		t.Errorf("double(12) = %v, want converter error", err)
+	}
+
+	// collections of registered types convert element by element
+	sum := func(ms []money) money {
+		total := money{currency: ms[0].currency}
+		for _, m := range ms {
+			total.cents += m.cents
+		}
+		return total
+	}
+	largest := func(ms map[string]money) string {
+		best := ""
+		for k, m := range ms {
+			if best == "" || m.cents > ms[best].cents {
+				best = k
+			}
+		}
+		return best
+	}
+	fns, err := convert.MakeStringDict(map[string]interface{}{"sum": sum, "largest": largest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = execWithThread(&starlark.Thread{}, `
+total = sum(["1.50 EUR", "2.25 EUR"])
+pair = sum(("0.10 EUR", "0.20 EUR"))
+top = largest({"rent": "900.00 EUR", "tip": "1.00 EUR"})
+`, fns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res["total"]; got != starlark.String("3.75 EUR") {
+		t.Errorf("total = %v", got)
+	}
+	if got := res["pair"]; got != starlark.String("0.30 EUR") {
+		t.Errorf("pair = %v", got)
+	}
+	if got := res["top"]; got != starlark.String("rent") {
+		t.Errorf("top = %v", got)
+	}
+	_, err = execWithThread(&starlark.Thread{}, `sum(["1.50 EUR", 2])`, fns)
+	if err == nil || !strings.Contains(err.Error(), "slice element 1: want a money string") {
+		t.Errorf("sum() with a bad element = %v", err)
+	}
+
+	var decoded ledgerEntry
+	err = convert.Decode(evalValue(t, `{"memo": "tip", "amount": "0.99 GBP"}`), &decoded, convert.DecodeTag("starlark"))
+	if err != nil || decoded.Amount != (money{99, "GBP"}) {
+		t.Errorf("Decode() = %+v, %v", decoded, err)
+	}
+	err = convert.Decode(evalValue(t, `{"amount": "oops"}`), &decoded, convert.DecodeTag("starlark"))
+	var de *convert.DecodeError
+	if !errors.As(err, &de) || de.Path != "amount" {
+		t.Errorf("Decode() error = %v, want a DecodeError at amount", err)
+	}
+}
+
+type percent int
+
+type quota struct {
+	Used percent `starlark:"used"`
+}
+
+// percentTypes returns a registry converting percent values from and to
+// strings like "50%".
+func percentTypes() *convert.TypeRegistry {
+	r := convert.NewTypeRegistry()
+	r.RegisterType(reflect.TypeOf(percent(0)),
+		func(v reflect.Value) (starlark.Value, error) {
+			return starlark.String(fmt.Sprintf("%d%%", v.Int())), nil
+		},
+		func(v starlark.Value) (reflect.Value, error) {
+			var p percent
+			s, ok := starlark.AsString(v)
+			if !ok {
+				return reflect.Value{}, fmt.Errorf("want a percent string, got %s", v.Type())
+			}
+			if _, err := fmt.Sscanf(s, "%d%%", &p); err != nil {
+				return reflect.Value{}, fmt.Errorf("bad percent %q", s)
+			}
+			return reflect.ValueOf(p), nil
+		})
+	return r
+}
+
+// TestTypeRegistry verifies the conversions of a registry apply to the
+// values converted with it and to the calls made on a thread holding it,
+// and nowhere else.
+func TestTypeRegistry(t *testing.T) {
+	r := percentTypes()
+	half := func(p percent) percent { return p / 2 }
+	q := &quota{Used: 40}
+
+	scoped, err := r.MakeStringDict(map[string]interface{}{"q": q})
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := convert.MakeStringDict(map[string]interface{}{"half": half, "q": q})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the fields of a value converted with the registry use it
+	res, err := execWithThread(&starlark.Thread{}, `
+used = q.used
+q.used = "60%"
+`, scoped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res["used"]; got != starlark.String("40%") {
+		t.Errorf("used = %v", got)
+	}
+	if q.Used != 60 {
+		t.Errorf("q.Used = %d", q.Used)
+	}
+
+	// a thread holding the registry converts the calls made on it
+	thread := &starlark.Thread{}
+	thread.SetLocal(convert.TypesKey, r)
+	res, err = execWithThread(thread, `out = half("50%")`, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res["out"]; got != starlark.String("25%") {
+		t.Errorf("half on a scoped thread = %v", got)
+	}
+
+	// elsewhere the built-in conversions apply
+	res, err = execWithThread(&starlark.Thread{}, `
+out = half(50)
+used = q.used
+`, plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res["out"]; got != starlark.MakeInt(25) {
+		t.Errorf("half = %v", got)
+	}
+	if got := res["used"]; got != starlark.MakeInt(60) {
+		t.Errorf("used = %v", got)
+	}
+	if _, err := execWithThread(&starlark.Thread{}, `half("50%")`, plain); err == nil {
+		t.Error(`half("50%") succeeded without the registry`)
+	}
+
+	var decoded quota
+	if err := convert.Decode(evalValue(t, `{"used": "5%"}`), &decoded, convert.DecodeTypes(r)); err != nil || decoded.Used != 5 {
+		t.Errorf("Decode() = %+v, %v", decoded, err)
+	}
+	if err := convert.Decode(evalValue(t, `{"used": "5%"}`), &decoded); err == nil {
+		t.Error("Decode() without the registry succeeded")
+	}
+}
@@ -258,8 +258,8 @@ func TestToValue(t *testing.T) {
 			want: startime.Time(now),
 		},
 		{
-			name:    "unsupported type: channel",
-			v:       make(chan int),
+			name:    "unsupported type: uintptr",
+			v:       uintptr(1),
 			want:    nil,
 			wantErr: true,
 		},
@@ -1056,7 +1056,7 @@ assert.Eq(type(t), "starlight_struct<convert_test.mockStr>")
 		{
 			name: "invalid key",
 			data: map[interface{}]interface{}{
-				complex(1, 2): "a",
+				uintptr(1): "a",
 			},
 			wantErrConv: true,
 		},
@@ -1066,7 +1066,7 @@ assert.Eq(type(t), "starlight_struct<convert_test.mockStr>")
 			// methods that cannot return errors)
 			name: "unsupported value degrades to wrapper",
 			data: map[interface{}]interface{}{
-				"b": complex(3, 4),
+				"b": uintptr(3),
 			},
 			codeSnippet: `
 assert.Eq(type(data), "dict")
@@ -0,0 +1,154 @@
+package starlight
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+// EventErrorPolicy decides what EventBus.Emit does when a handler fails.
+type EventErrorPolicy int
+
+const (
+	// ContinueOnError calls every handler even if some of them fail.
+	ContinueOnError EventErrorPolicy = iota
+	// StopOnError stops at the first handler that fails.
+	StopOnError
+)
+
+// EventBus dispatches host events to Starlark handlers. Scripts register
+// handlers while they run through the builtin returned by Builtin (usually
+// exposed as "on"):
+//
+//	def notify(order):
+//	    ...
+//	on("order.created", notify)
+//
+// and the host emits events with Emit. Handlers of an event are called in
+// the order they were registered.
+type EventBus struct {
+	_        convert.DoNotCompare
+	mu       sync.RWMutex
+	handlers map[string][]starlark.Callable
+	policy   EventErrorPolicy
+}
+
+// HandlerResult is the outcome of calling one event handler.
+type HandlerResult struct {
+	Handler string      // the handler name and, for Starlark functions, its position
+	Value   interface{} // the handler's return value, converted as by convert.FromValue
+	Err     error       // the error the handler raised, if any
+}
+
+// NewEventBus returns an empty EventBus applying the given error policy.
+func NewEventBus(policy EventErrorPolicy) *EventBus {
+	return &EventBus{handlers: make(map[string][]starlark.Callable), policy: policy}
+}
+
+// Builtin returns the "on(event, handler)" builtin scripts use to register
+// a handler for an event.
+func (b *EventBus) Builtin() *starlark.Builtin {
+	return starlark.NewBuiltin("on", func(_ *starlark.Thread, fn *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		var (
+			event   string
+			handler starlark.Callable
+		)
+		if err := starlark.UnpackPositionalArgs(fn.Name(), args, kwargs, 2, &event, &handler); err != nil {
+			return nil, err
+		}
+		if event == "" {
+			return nil, fmt.Errorf("%s: event name must not be empty", fn.Name())
+		}
+		b.On(event, handler)
+		return starlark.None, nil
+	})
+}
+
+// On registers handler for event from Go; it is what the builtin calls.
+func (b *EventBus) On(event string, handler starlark.Callable) {
+	b.mu.Lock()
+	b.handlers[event] = append(b.handlers[event], handler)
+	b.mu.Unlock()
+}
+
+// Off removes all handlers of event.
+func (b *EventBus) Off(event string) {
+	b.mu.Lock()
+	delete(b.handlers, event)
+	b.mu.Unlock()
+}
+
+// Handlers returns the number of handlers registered for event.
+func (b *EventBus) Handlers(event string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.handlers[event])
+}
+
+// Emit calls every handler registered for event, in registration order,
+// with payload converted as by convert.ToValue and frozen, so one handler
+// cannot change what the next one sees. Handlers run one after the other on
+// a thread carrying ctx (see WithContext).
+//
+// Emit returns the result of every handler called. Under StopOnError it
+// stops at the first failing handler; under ContinueOnError it calls all of
+// them. Either way the returned error is non-nil if any handler failed, and
+// reports the first failure.
+func (b *EventBus) Emit(ctx context.Context, event string, payload interface{}) ([]HandlerResult, error) {
+	b.mu.RLock()
+	handlers := append([]starlark.Callable(nil), b.handlers[event]...)
+	b.mu.RUnlock()
+	if len(handlers) == 0 {
+		return nil, nil
+	}
+
+	arg, err := convert.ToValue(payload)
+	if err != nil {
+		return nil, fmt.Errorf("event %q: payload: %v", event, err)
+	}
+	arg.Freeze()
+
+	rs, err := newRunConfig([]RunOption{WithContext(ctx)}).start()
+	if err != nil {
+		return nil, err
+	}
+	defer rs.stop()
+	results := make([]HandlerResult, 0, len(handlers))
+	var (
+		failed   int
+		firstErr error
+	)
+	for _, h := range handlers {
+		thread := rs.newThread(nil)
+		thread.Name = "event " + event
+		ret, err := starlark.Call(thread, h, starlark.Tuple{convert.BindThread(arg, thread)}, nil)
+		res := HandlerResult{Handler: handlerName(h), Err: err}
+		if err == nil {
+			res.Value = convert.FromValue(ret)
+		} else {
+			failed++
+			if firstErr == nil {
+				firstErr = fmt.Errorf("handler %s: %w", res.Handler, err)
+			}
+		}
+		results = append(results, res)
+		if err != nil && b.policy == StopOnError {
+			break
+		}
+	}
+	if firstErr != nil {
+		return results, fmt.Errorf("event %q: %d of %d handlers failed, first: %w", event, failed, len(results), firstErr)
+	}
+	return results, nil
+}
+
+// handlerName describes a handler for HandlerResult.
+func handlerName(h starlark.Callable) string {
+	if fn, ok := h.(*starlark.Function); ok {
+		return fmt.Sprintf("%s (%s)", fn.Name(), fn.Position())
+	}
+	return h.Name()
+}
@@ -0,0 +1,138 @@
+package starlight
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+var errTest = errors.New("test failure")
+
+func TestEventBus(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"a.star": `
+def audit(order):
+    return "audit " + order["id"]
+def fail(order):
+    fail_now()
+on("order.created", audit)
+on("order.created", fail)
+`,
+		"b.star": `
+def mail(order):
+    return order["total"] * 2
+on("order.created", mail)
+on("user.signup", lambda u: u)
+`,
+	})
+	c := New(dir)
+	failNow := starlark.NewBuiltin("fail_now", func(*starlark.Thread, *starlark.Builtin, starlark.Tuple, []starlark.Tuple) (starlark.Value, error) {
+		return nil, errTest
+	})
+
+	for _, policy := range []EventErrorPolicy{ContinueOnError, StopOnError} {
+		bus := NewEventBus(policy)
+		globals := map[string]interface{}{"on": bus.Builtin(), "fail_now": failNow}
+		for _, f := range []string{"a.star", "b.star"} {
+			if _, err := c.Run(f, globals); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if n := bus.Handlers("order.created"); n != 3 {
+			t.Fatalf("handlers = %d, want 3", n)
+		}
+
+		payload := map[string]interface{}{"id": "o-1", "total": 21}
+		res, err := bus.Emit(context.Background(), "order.created", payload)
+		if err == nil || !strings.Contains(err.Error(), "1 of") || !strings.Contains(err.Error(), "test failure") {
+			t.Fatalf("expected a handler failure, got %v", err)
+		}
+		if res[0].Value != "audit o-1" || !strings.HasPrefix(res[0].Handler, "audit (a.star:2:1)") {
+			t.Fatalf("first result = %+v", res[0])
+		}
+		if res[1].Err == nil {
+			t.Fatalf("second result should have failed: %+v", res[1])
+		}
+		switch policy {
+		case ContinueOnError:
+			if len(res) != 3 || res[2].Value != int64(42) {
+				t.Fatalf("continue results = %+v", res)
+			}
+		case StopOnError:
+			if len(res) != 2 {
+				t.Fatalf("stop results = %+v", res)
+			}
+		}
+
+		res, err = bus.Emit(context.Background(), "user.signup", "bob")
+		if err != nil || !reflect.DeepEqual(res, []HandlerResult{{Handler: res[0].Handler, Value: "bob"}}) {
+			t.Fatalf("signup = %+v, %v", res, err)
+		}
+		if res, err := bus.Emit(context.Background(), "nobody.listens", nil); res != nil || err != nil {
+			t.Fatalf("unhandled event = %+v, %v", res, err)
+		}
+		bus.Off("user.signup")
+		if bus.Handlers("user.signup") != 0 {
+			t.Fatal("expected Off to remove the handlers")
+		}
+	}
+}
+
+// TestEventBusPayloadFrozen verifies handlers cannot modify the payload
+// seen by later handlers.
+func TestEventBusPayloadFrozen(t *testing.T) {
+	bus := NewEventBus(ContinueOnError)
+	_, err := Eval([]byte(`
+def h(p):
+    p["x"] = 2
+on("e", h)
+`), map[string]interface{}{"on": bus.Builtin()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := map[string]int{"x": 1}
+	if _, err := bus.Emit(context.Background(), "e", payload); err == nil || !strings.Contains(err.Error(), "frozen") {
+		t.Fatalf("expected a frozen payload error, got %v", err)
+	}
+	if payload["x"] != 1 {
+		t.Fatalf("payload was modified: %v", payload)
+	}
+}
+
+// TestEventBusContext verifies Emit honors the context.
+func TestEventBusContext(t *testing.T) {
+	bus := NewEventBus(ContinueOnError)
+	_, err := Eval([]byte(`
+def spin(_):
+    for i in range(1 << 40):
+        pass
+on("e", spin)
+`), map[string]interface{}{"on": bus.Builtin()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := bus.Emit(ctx, "e", nil); err == nil || !strings.Contains(err.Error(), "context deadline exceeded") {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
+
+func TestEventBusBuiltinArgs(t *testing.T) {
+	bus := NewEventBus(ContinueOnError)
+	globals := map[string]interface{}{"on": bus.Builtin()}
+	for code, want := range map[string]string{
+		`on("e")`:             "got 1 arguments, want 2",
+		`on("", lambda x: x)`: "event name must not be empty",
+		`on("e", 1)`:          "want callable",
+	} {
+		if _, err := Eval([]byte(code), globals, nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error containing %q, got %v", code, want, err)
+		}
+	}
+}
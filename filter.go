@@ -0,0 +1,113 @@
+package starlight
+
+import (
+	"sort"
+	"strings"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// resultFilter shapes the globals a run returns; see the Exclude*,
+// IncludeOnly and ReportRebound options.
+type resultFilter struct {
+	noCallables bool
+	noPrivate   bool
+	noInputs    bool
+	include     map[string]bool // nil means every name
+	rebound     *[]string
+}
+
+// ExcludeCallables drops functions, builtins and modules from the globals
+// a run returns, keeping only data.
+func ExcludeCallables() RunOption {
+	return func(rc *runConfig) {
+		rc.filter.noCallables = true
+	}
+}
+
+// ExcludePrivate drops the globals whose names start with "_" from the
+// globals a run returns.
+func ExcludePrivate() RunOption {
+	return func(rc *runConfig) {
+		rc.filter.noPrivate = true
+	}
+}
+
+// IncludeOnly restricts the globals a run returns to the given names; names
+// the script did not define are simply absent. Repeated IncludeOnly options
+// add up.
+func IncludeOnly(names ...string) RunOption {
+	return func(rc *runConfig) {
+		if rc.filter.include == nil {
+			rc.filter.include = make(map[string]bool, len(names))
+		}
+		for _, n := range names {
+			rc.filter.include[n] = true
+		}
+	}
+}
+
+// ExcludeInputs drops the globals named like one of the globals passed in,
+// so a run returns only what the script introduced. A script can still
+// rebind an input at top level; see ReportRebound.
+func ExcludeInputs() RunOption {
+	return func(rc *runConfig) {
+		rc.filter.noInputs = true
+	}
+}
+
+// ReportRebound stores into *names, sorted, the input globals the script
+// rebound at top level, whether or not the other options keep them in the
+// result. Every run writes *names, so it must not be shared by concurrent
+// runs, such as the inputs of a RunBatch.
+func ReportRebound(names *[]string) RunOption {
+	return func(rc *runConfig) {
+		rc.filter.rebound = names
+	}
+}
+
+// apply returns the globals of out, defined by a run whose predeclared
+// globals were in, that the filter keeps. It returns out itself when the
+// filter keeps everything.
+func (f *resultFilter) apply(out, in starlark.StringDict) starlark.StringDict {
+	if f.rebound != nil {
+		rebound := []string{}
+		for name := range out {
+			if _, ok := in[name]; ok {
+				rebound = append(rebound, name)
+			}
+		}
+		sort.Strings(rebound)
+		*f.rebound = rebound
+	}
+	if !f.noCallables && !f.noPrivate && !f.noInputs && f.include == nil {
+		return out
+	}
+	kept := make(starlark.StringDict, len(out))
+	for name, v := range out {
+		if f.include != nil && !f.include[name] {
+			continue
+		}
+		if f.noPrivate && strings.HasPrefix(name, "_") {
+			continue
+		}
+		if _, ok := in[name]; ok && f.noInputs {
+			continue
+		}
+		if f.noCallables && isCode(v) {
+			continue
+		}
+		kept[name] = v
+	}
+	return kept
+}
+
+// isCode reports whether v is a function or module rather than data.
+func isCode(v starlark.Value) bool {
+	switch v.(type) {
+	case starlark.Callable, *starlarkstruct.Module:
+		return true
+	}
+	return false
+}
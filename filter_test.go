@@ -0,0 +1,89 @@
+package starlight
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+
+	startime "go.starlark.net/lib/time"
+)
+
+const filterScript = `
+load("helpers.star", "helper")
+def _private_helper():
+    return 1
+def public_fn():
+    return 2
+_secret = "s"
+name = "svc"
+port = 8080
+clock = time
+limit = 20
+`
+
+// resultNames returns the sorted keys of a run result.
+func resultNames(m map[string]interface{}) string {
+	names := make([]string, 0, len(m))
+	for k := range m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return fmt.Sprint(names)
+}
+
+// TestResultFilter verifies the result-shaping options on Eval and
+// Cache.Run, alone and combined. Names bound by load() are local to the
+// script and never part of the result.
+func TestResultFilter(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"config.star":  filterScript,
+		"helpers.star": "def helper():\n    pass\n",
+	})
+	c := New(dir)
+	inputs := func() map[string]interface{} {
+		return map[string]interface{}{"limit": 5, "env": "prod", "time": startime.Module}
+	}
+
+	tests := []struct {
+		name string
+		opts []RunOption
+		want string
+	}{
+		{"none", nil, "[_private_helper _secret clock limit name port public_fn]"},
+		{"callables", []RunOption{ExcludeCallables()}, "[_secret limit name port]"},
+		{"private", []RunOption{ExcludePrivate()}, "[clock limit name port public_fn]"},
+		{"inputs", []RunOption{ExcludeInputs()}, "[_private_helper _secret clock name port public_fn]"},
+		{"include", []RunOption{IncludeOnly("name", "missing"), IncludeOnly("port")}, "[name port]"},
+		{"data only", []RunOption{ExcludeCallables(), ExcludePrivate(), ExcludeInputs()}, "[name port]"},
+	}
+	for _, tt := range tests {
+		res, err := c.Run("config.star", inputs(), tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := resultNames(res); got != tt.want {
+			t.Errorf("%s: Run returned %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	var rebound []string
+	res, err := Eval([]byte(filterScript), inputs(), c.Load, ExcludeCallables(), ExcludePrivate(), ReportRebound(&rebound))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resultNames(res); got != "[limit name port]" {
+		t.Errorf("Eval returned %s", got)
+	}
+	if res["limit"] != int64(20) {
+		t.Errorf("limit = %v, want 20", res["limit"])
+	}
+	if fmt.Sprint(rebound) != "[limit]" {
+		t.Errorf("rebound = %v, want [limit]", rebound)
+	}
+	if _, err := Eval([]byte("x = env\n"), inputs(), nil, ReportRebound(&rebound)); err != nil {
+		t.Fatal(err)
+	}
+	if rebound == nil || len(rebound) != 0 {
+		t.Errorf("rebound = %#v, want empty", rebound)
+	}
+}
@@ -0,0 +1,318 @@
+package starlight
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+// CacheGroup hands out Caches for many tenants of one process. Each tenant
+// has its own directories, globals and module namespace, exactly like a
+// Cache made with WithGlobals, but the compiled programs are shared: a
+// script whose source is byte-identical to one another tenant already
+// compiled, under the same name and predeclared names, is compiled only
+// once for the whole group.
+//
+// The group can bound the total memory of the compiled programs it holds,
+// estimated by their serialized size (see starlark.Program.Write). When a
+// new program would exceed the budget, the least recently used programs are
+// evicted from the group and from every tenant holding them; those tenants
+// compile them again on their next run.
+type CacheGroup struct {
+	_        convert.DoNotCompare
+	mu       sync.Mutex
+	budget   int64 // 0 means unlimited
+	used     int64
+	tick     int64                    // LRU clock
+	programs map[string]*groupProgram // by source hash and scriptCacheKey
+	byProg   map[*starlark.Program]*groupProgram
+	tenants  map[string]*tenant
+	byCache  map[*Cache]*tenant
+}
+
+// TenantStats reports the compile work and memory of one tenant of a
+// CacheGroup.
+type TenantStats struct {
+	Scripts   int   // programs cached by the tenant, modules included
+	Bytes     int64 // estimated size of the group programs the tenant uses
+	Compiles  int64 // programs compiled for the tenant
+	Shared    int64 // programs the tenant took from the group instead of compiling
+	Evictions int64 // programs the tenant lost to the memory budget
+}
+
+type tenant struct {
+	cache *Cache
+	stats TenantStats
+}
+
+// groupProgram is a compiled program shared by the tenants of a group.
+type groupProgram struct {
+	prog     *starlark.Program
+	size     int64
+	lastUsed int64
+	// refs lists, per tenant cache, the c.scripts keys holding prog
+	refs map[*Cache]map[string]bool
+}
+
+// NewCacheGroup returns an empty group whose compiled programs may use up
+// to budget bytes in total; a budget of zero or less means no limit.
+func NewCacheGroup(budget int64) *CacheGroup {
+	if budget < 0 {
+		budget = 0
+	}
+	return &CacheGroup{
+		budget:   budget,
+		programs: make(map[string]*groupProgram),
+		byProg:   make(map[*starlark.Program]*groupProgram),
+		tenants:  make(map[string]*tenant),
+		byCache:  make(map[*Cache]*tenant),
+	}
+}
+
+// NewTenant creates the Cache of the tenant name, looking for scripts in
+// dirs and passing globals to the modules it loads, as WithGlobals does. It
+// fails if the name is taken or no directories are given.
+func (g *CacheGroup) NewTenant(name string, globals map[string]interface{}, dirs ...string) (*Cache, error) {
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no directories given")
+	}
+	dict, err := convert.MakeStringDict(globals)
+	if err != nil {
+		return nil, err
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if _, ok := g.tenants[name]; ok {
+		return nil, fmt.Errorf("starlight: tenant %q already exists", name)
+	}
+	c := newCache(DirLoader(dirs), dict)
+	c.group = g
+	// modules compile through the group too
+	c.cache.program = c.program
+	t := &tenant{cache: c}
+	g.tenants[name] = t
+	g.byCache[c] = t
+	return c, nil
+}
+
+// Tenant returns the Cache of the tenant name, or nil if there is none.
+func (g *CacheGroup) Tenant(name string) *Cache {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if t, ok := g.tenants[name]; ok {
+		return t.cache
+	}
+	return nil
+}
+
+// RemoveTenant resets the tenant name and removes it from the group.
+func (g *CacheGroup) RemoveTenant(name string) {
+	if !g.Reset(name) {
+		return
+	}
+	g.mu.Lock()
+	if t, ok := g.tenants[name]; ok {
+		delete(g.byCache, t.cache)
+		delete(g.tenants, name)
+	}
+	g.mu.Unlock()
+}
+
+// Reset clears the compiled programs and loaded modules of the tenant
+// name, like Cache.Reset, and releases the group programs no other tenant
+// uses. It reports whether the tenant exists; its statistics are kept.
+func (g *CacheGroup) Reset(name string) bool {
+	c := g.Tenant(name)
+	if c == nil {
+		return false
+	}
+	c.Reset()
+	return true
+}
+
+// Stats returns the statistics of the tenant name, and whether it exists.
+func (g *CacheGroup) Stats(name string) (TenantStats, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	t, ok := g.tenants[name]
+	if !ok {
+		return TenantStats{}, false
+	}
+	st := t.stats
+	for _, p := range g.programs {
+		if len(p.refs[t.cache]) > 0 {
+			st.Bytes += p.size
+		}
+	}
+	t.cache.mu.Lock()
+	st.Scripts = len(t.cache.scripts)
+	t.cache.mu.Unlock()
+	return st, true
+}
+
+// Used returns the estimated size of the programs the group holds.
+func (g *CacheGroup) Used() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.used
+}
+
+// program returns the program of src for the tenant cache c under the
+// scriptCacheKey key, compiling it only if no tenant did, and stores it in
+// c.scripts.
+func (g *CacheGroup) program(c *Cache, key, filename string, src []byte, version string, dict starlark.StringDict) (*starlark.Program, error) {
+	sum := sha256.Sum256(src)
+	gkey := hex.EncodeToString(sum[:]) + "\x00" + key
+
+	g.mu.Lock()
+	p, shared := g.programs[gkey]
+	if !shared {
+		// compile without holding the group lock
+		g.mu.Unlock()
+		_, prog, err := starlark.SourceProgramOptions(dialectOptions, filename, src, dict.Has)
+		if err != nil {
+			return nil, err
+		}
+		size, err := programSize(prog)
+		if err != nil {
+			return nil, err
+		}
+		g.mu.Lock()
+		// another tenant may have compiled the same program meanwhile
+		if p, shared = g.programs[gkey]; !shared {
+			if err := g.makeRoom(filename, size); err != nil {
+				g.mu.Unlock()
+				return nil, err
+			}
+			p = &groupProgram{prog: prog, size: size, refs: make(map[*Cache]map[string]bool)}
+			g.programs[gkey] = p
+			g.byProg[prog] = p
+			g.used += size
+		}
+	}
+	defer g.mu.Unlock()
+	if t := g.byCache[c]; t != nil {
+		if shared {
+			t.stats.Shared++
+		} else {
+			t.stats.Compiles++
+		}
+	}
+	g.tick++
+	p.lastUsed = g.tick
+	if p.refs[c] == nil {
+		p.refs[c] = make(map[string]bool)
+	}
+	p.refs[c][key] = true
+	c.mu.Lock()
+	c.store(key, p.prog, version)
+	c.mu.Unlock()
+	return p.prog, nil
+}
+
+// touch marks the group program prog as just used by a tenant which had
+// it cached already.
+func (g *CacheGroup) touch(prog *starlark.Program) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if p := g.byProg[prog]; p != nil {
+		g.tick++
+		p.lastUsed = g.tick
+	}
+}
+
+// makeRoom evicts the least recently used programs until size more bytes
+// fit in the budget. The caller holds g.mu.
+func (g *CacheGroup) makeRoom(filename string, size int64) error {
+	if g.budget == 0 {
+		return nil
+	}
+	if size > g.budget {
+		return fmt.Errorf("starlight: compiled %s needs %d bytes, over the group budget of %d", filename, size, g.budget)
+	}
+	for g.used+size > g.budget {
+		var (
+			oldestKey string
+			oldest    *groupProgram
+		)
+		for k, p := range g.programs {
+			if oldest == nil || p.lastUsed < oldest.lastUsed {
+				oldestKey, oldest = k, p
+			}
+		}
+		g.evict(oldestKey, oldest)
+	}
+	return nil
+}
+
+// evict drops the program p from the group and from the tenants holding
+// it. The caller holds g.mu.
+func (g *CacheGroup) evict(gkey string, p *groupProgram) {
+	for c, keys := range p.refs {
+		evicted := false
+		c.mu.Lock()
+		for key := range keys {
+			if c.scripts[key] == p.prog {
+				delete(c.scripts, key)
+				evicted = true
+			}
+		}
+		c.mu.Unlock()
+		if t := g.byCache[c]; evicted && t != nil {
+			t.stats.Evictions++
+		}
+	}
+	delete(g.programs, gkey)
+	delete(g.byProg, p.prog)
+	g.used -= p.size
+}
+
+// release forgets the references of c to group programs it no longer
+// holds, and drops the programs no tenant holds any more.
+func (g *CacheGroup) release(c *Cache) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for gkey, p := range g.programs {
+		keys := p.refs[c]
+		if len(keys) == 0 {
+			continue
+		}
+		c.mu.Lock()
+		for key := range keys {
+			if c.scripts[key] != p.prog {
+				delete(keys, key)
+			}
+		}
+		c.mu.Unlock()
+		if len(keys) == 0 {
+			delete(p.refs, c)
+		}
+		if len(p.refs) == 0 {
+			delete(g.programs, gkey)
+			delete(g.byProg, p.prog)
+			g.used -= p.size
+		}
+	}
+}
+
+// programSize estimates the memory of a compiled program by the size of
+// its serialized form.
+func programSize(p *starlark.Program) (int64, error) {
+	var n byteCounter
+	if err := p.Write(&n); err != nil {
+		return 0, err
+	}
+	return int64(n), nil
+}
+
+// byteCounter is an io.Writer that only counts what is written to it.
+type byteCounter int64
+
+func (b *byteCounter) Write(p []byte) (int, error) {
+	*b += byteCounter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,172 @@
+package starlight
+
+import (
+	"strings"
+	"testing"
+)
+
+// groupTenants creates tenants a and b of g, with the same library and
+// different main scripts.
+func groupTenants(t *testing.T, g *CacheGroup) (a, b *Cache) {
+	t.Helper()
+	lib := "who = tenant\n"
+	dirA := writeScripts(t, map[string]string{
+		"lib.star":  lib,
+		"main.star": "load(\"lib.star\", \"who\")\nout = who + \"/a\"\n",
+	})
+	dirB := writeScripts(t, map[string]string{
+		"lib.star":  lib,
+		"main.star": "load(\"lib.star\", \"who\")\nout = who + \"/b\" + suffix\n",
+	})
+	a, err := g.NewTenant("a", map[string]interface{}{"tenant": "A"}, dirA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = g.NewTenant("b", map[string]interface{}{"tenant": "B"}, dirB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, b
+}
+
+func runOut(t *testing.T, c *Cache) interface{} {
+	t.Helper()
+	res, err := c.Run("main.star", map[string]interface{}{"suffix": "!"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res["out"]
+}
+
+// TestCacheGroup verifies tenants keep isolated module namespaces while
+// identical scripts are compiled once for the group.
+func TestCacheGroup(t *testing.T) {
+	g := NewCacheGroup(0)
+	a, b := groupTenants(t, g)
+	if got := runOut(t, a); got != "A/a" {
+		t.Errorf("a out = %v", got)
+	}
+	if got := runOut(t, b); got != "B/b!" {
+		t.Errorf("b out = %v", got)
+	}
+	runOut(t, a)
+
+	sa, _ := g.Stats("a")
+	sb, _ := g.Stats("b")
+	if sa.Compiles != 2 || sa.Shared != 0 || sa.Scripts != 2 {
+		t.Errorf("a stats = %+v", sa)
+	}
+	if sb.Compiles != 1 || sb.Shared != 1 || sb.Scripts != 2 {
+		t.Errorf("b stats = %+v", sb)
+	}
+	if sa.Bytes <= 0 || sa.Bytes+sb.Bytes <= g.Used() {
+		t.Errorf("bytes a=%d b=%d used=%d: the shared library should count for both", sa.Bytes, sb.Bytes, g.Used())
+	}
+
+	// resetting a keeps the library b still uses
+	used := g.Used()
+	if !g.Reset("a") {
+		t.Fatal("Reset(a) = false")
+	}
+	sa, _ = g.Stats("a")
+	if sa.Scripts != 0 || sa.Bytes != 0 {
+		t.Errorf("a stats after reset = %+v", sa)
+	}
+	if u := g.Used(); u >= used || u != sb.Bytes {
+		t.Errorf("used after reset = %d, before %d, b uses %d", u, used, sb.Bytes)
+	}
+	if got := runOut(t, a); got != "A/a" {
+		t.Errorf("a out after reset = %v", got)
+	}
+	if sa, _ = g.Stats("a"); sa.Shared != 1 {
+		t.Errorf("a should take the library from the group, stats = %+v", sa)
+	}
+
+	if _, err := g.NewTenant("a", nil, t.TempDir()); err == nil {
+		t.Error("expected a duplicate tenant error")
+	}
+	if _, ok := g.Stats("nobody"); ok || g.Reset("nobody") || g.Tenant("nobody") != nil {
+		t.Error("unknown tenant reported as existing")
+	}
+	g.RemoveTenant("b")
+	if g.Tenant("b") != nil {
+		t.Error("b still exists")
+	}
+	if u := g.Used(); u != sa.Bytes {
+		t.Errorf("used after removing b = %d, want %d", u, sa.Bytes)
+	}
+}
+
+// TestCacheGroupBudget verifies the budget evicts least recently used
+// programs, which are then compiled again, and rejects programs that can
+// never fit.
+func TestCacheGroupBudget(t *testing.T) {
+	probe := NewCacheGroup(0)
+	a, b := groupTenants(t, probe)
+	runOut(t, a)
+	runOut(t, b)
+	total := probe.Used()
+
+	g := NewCacheGroup(total - 1)
+	a, b = groupTenants(t, g)
+	for i := 0; i < 3; i++ {
+		if got := runOut(t, a); got != "A/a" {
+			t.Fatalf("a out = %v", got)
+		}
+		if got := runOut(t, b); got != "B/b!" {
+			t.Fatalf("b out = %v", got)
+		}
+		if u := g.Used(); u > total-1 {
+			t.Fatalf("used %d over budget %d", u, total-1)
+		}
+	}
+	sa, _ := g.Stats("a")
+	sb, _ := g.Stats("b")
+	if sa.Evictions+sb.Evictions == 0 {
+		t.Errorf("no evictions: a=%+v b=%+v", sa, sb)
+	}
+
+	tiny := NewCacheGroup(1)
+	c, err := tiny.NewTenant("t", nil, writeScripts(t, map[string]string{"main.star": "out = 1\n"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Run("main.star", nil); err == nil || !strings.Contains(err.Error(), "over the group budget of 1") {
+		t.Fatalf("expected a budget error, got %v", err)
+	}
+}
+
+// TestCacheGroupRecency verifies a program a tenant keeps running from its
+// own cache counts as recently used, so the budget evicts colder ones.
+func TestCacheGroupRecency(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"hot.star": "out = 1\n",
+		"c_1.star": "out = 2\n",
+		"c_2.star": "out = 3\n",
+	})
+	probe := NewCacheGroup(0)
+	p, err := probe.NewTenant("p", nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"hot.star", "c_1.star"} {
+		if _, err := p.Run(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// room for two of the three programs
+	g := NewCacheGroup(probe.Used())
+	c, err := g.NewTenant("t", nil, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"hot.star", "c_1.star", "hot.star", "c_2.star", "hot.star"} {
+		if _, err := c.Run(f, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if st, _ := g.Stats("t"); st.Compiles != 3 || st.Evictions != 1 {
+		t.Errorf("hot program was evicted: stats = %+v", st)
+	}
+}
@@ -0,0 +1,211 @@
+package starlight
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// SourceLoader supplies the sources of the scripts a Cache runs and loads.
+type SourceLoader interface {
+	// Load returns the source of the script name, and its version. The
+	// Cache only reads the version when it compiles the script: noticing
+	// that a script changed also takes the loader implementing Versioner,
+	// and without it the compiled script is kept until Cache.Forget or
+	// Cache.Reset, whatever the version. An unknown name must fail with an
+	// error wrapping fs.ErrNotExist, so a ChainLoader moves on to its next
+	// loader.
+	Load(name string) (src []byte, version string, err error)
+}
+
+// Versioner is implemented by SourceLoaders that can tell the current
+// version of a script without loading it. A Cache whose loader implements
+// it checks, on each run, the version of every versioned script it has
+// compiled, and compiles the script again if the version changed. Modules
+// loaded once for the whole cache (see SetModuleRunGlobals) are not
+// checked.
+type Versioner interface {
+	Version(name string) (string, error)
+}
+
+// Lister is implemented by SourceLoaders that can enumerate their scripts,
+// which PluginHost and Cache.Preload require. Names are slash-separated.
+type Lister interface {
+	List() ([]string, error)
+}
+
+// DirLoader loads scripts from OS directories, searched in order; it is the
+// loader of the Caches made by New and WithGlobals. Names are relative to
+// the directories, and a name that would resolve outside a directory (e.g.
+// "../secret.star") is never read from it. Scripts are unversioned.
+type DirLoader []string
+
+// Load implements SourceLoader.
+func (d DirLoader) Load(name string) ([]byte, string, error) {
+	for _, dir := range d {
+		full := filepath.Join(dir, name)
+		// Containment: filepath.Join cleans embedded ".." segments, so a
+		// script-controlled name like "../secret.star" (Run and the load()
+		// path both reach here) can resolve above dir and read a sibling or
+		// parent file. Skip any candidate that escapes dir; a name that
+		// stays within a *different* configured dir is still served from
+		// that one, and only a name that escapes every dir falls through to
+		// not-found. This is defense in depth — confinement is not a
+		// guarantee of New() (real sandboxing belongs to the host layer),
+		// but the search scope should not silently reach outside the
+		// directories it was given.
+		if !withinDir(dir, full) {
+			continue
+		}
+		if b, err := ioutil.ReadFile(full); err == nil {
+			return b, "", nil
+		}
+	}
+	return nil, "", notFoundError(fmt.Sprintf("cannot find file %q in any of the configured directories %q", name, []string(d)))
+}
+
+// List implements Lister: it returns the files of every directory and its
+// subdirectories. A name present in several directories is listed once,
+// as Load serves it from the first directory that has it; entries that
+// would resolve outside their directory are skipped, as Load refuses them.
+func (d DirLoader) List() ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, dir := range d {
+		err := filepath.WalkDir(dir, func(full string, de fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if de.IsDir() || !withinDir(dir, full) {
+				return nil
+			}
+			rel, err := filepath.Rel(dir, full)
+			if err != nil {
+				return err
+			}
+			rel = filepath.ToSlash(rel)
+			if !seen[rel] {
+				seen[rel] = true
+				names = append(names, rel)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// MapLoader loads scripts from memory, mapping names to sources, e.g. for
+// tests or scripts kept in a database. The version of a script is a hash of
+// its source, so a Cache compiles a script again once its entry changes;
+// the map must not be modified while runs use it.
+type MapLoader map[string]string
+
+// Load implements SourceLoader.
+func (m MapLoader) Load(name string) ([]byte, string, error) {
+	src, ok := m[name]
+	if !ok {
+		return nil, "", notFoundError(fmt.Sprintf("cannot find script %q", name))
+	}
+	return []byte(src), sourceVersion(src), nil
+}
+
+// Version implements Versioner.
+func (m MapLoader) Version(name string) (string, error) {
+	src, ok := m[name]
+	if !ok {
+		return "", notFoundError(fmt.Sprintf("cannot find script %q", name))
+	}
+	return sourceVersion(src), nil
+}
+
+// List implements Lister.
+func (m MapLoader) List() ([]string, error) {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// sourceVersion returns the version of a source held by a MapLoader.
+func sourceVersion(src string) string {
+	sum := sha256.Sum256([]byte(src))
+	return hex.EncodeToString(sum[:8])
+}
+
+// ChainLoader tries its loaders in order and serves each script from the
+// first one that has it, e.g. tenant overrides before a shared library.
+type ChainLoader []SourceLoader
+
+// Load implements SourceLoader.
+func (c ChainLoader) Load(name string) ([]byte, string, error) {
+	for _, l := range c {
+		b, v, err := l.Load(name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			return b, v, err
+		}
+	}
+	return nil, "", notFoundError(fmt.Sprintf("cannot find script %q in any of %d loaders", name, len(c)))
+}
+
+// Version implements Versioner. Loaders of the chain that do not implement
+// Versioner are asked through Load.
+func (c ChainLoader) Version(name string) (string, error) {
+	for _, l := range c {
+		var (
+			v   string
+			err error
+		)
+		if vl, ok := l.(Versioner); ok {
+			v, err = vl.Version(name)
+		} else {
+			_, v, err = l.Load(name)
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return v, err
+		}
+	}
+	return "", notFoundError(fmt.Sprintf("cannot find script %q in any of %d loaders", name, len(c)))
+}
+
+// List implements Lister, listing the scripts of every loader of the chain
+// that implements it.
+func (c ChainLoader) List() ([]string, error) {
+	seen := make(map[string]bool)
+	var names []string
+	for _, l := range c {
+		ll, ok := l.(Lister)
+		if !ok {
+			continue
+		}
+		some, err := ll.List()
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range some {
+			if !seen[n] {
+				seen[n] = true
+				names = append(names, n)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// notFoundError reports a missing script; it wraps fs.ErrNotExist.
+type notFoundError string
+
+func (e notFoundError) Error() string { return string(e) }
+
+func (e notFoundError) Unwrap() error { return fs.ErrNotExist }
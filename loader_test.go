@@ -0,0 +1,112 @@
+package starlight
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// dbLoader is a loader backed by a store the test controls, without
+// Versioner or Lister.
+type dbLoader map[string]string
+
+func (d dbLoader) Load(name string) ([]byte, string, error) {
+	src, ok := d[name]
+	if !ok {
+		return nil, "", fmt.Errorf("no row %q: %w", name, fs.ErrNotExist)
+	}
+	return []byte(src), "", nil
+}
+
+// TestMapLoader verifies a Cache built on a MapLoader runs and loads
+// scripts from memory and recompiles a script once its source changes.
+func TestMapLoader(t *testing.T) {
+	scripts := MapLoader{
+		"lib.star":  "def greet(n):\n    return prefix + n\n",
+		"main.star": "load(\"lib.star\", \"greet\")\nout = greet(name)\n",
+	}
+	c, err := WithLoader(scripts, map[string]interface{}{"prefix": "hi "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Run("main.star", map[string]interface{}{"name": "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["out"] != "hi bob" {
+		t.Fatalf("out = %v", res["out"])
+	}
+
+	scripts["main.star"] = "out = name + \"!\"\n"
+	res, err = c.Run("main.star", map[string]interface{}{"name": "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["out"] != "bob!" {
+		t.Fatalf("changed script not recompiled, out = %v", res["out"])
+	}
+
+	delete(scripts, "main.star")
+	if _, err := c.Run("main.star", map[string]interface{}{"name": "bob"}); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected a not-found error, got %v", err)
+	}
+	if _, err := WithLoader(nil, nil); err == nil {
+		t.Fatal("expected an error for a nil loader")
+	}
+}
+
+// TestChainLoader verifies a chain serves each script from the first loader
+// that has it, lists all of them, and reports versions through loaders that
+// only implement Load.
+func TestChainLoader(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"lib.star":  "who = \"disk\"\n",
+		"disk.star": "out = 1\n",
+	})
+	overrides := MapLoader{"lib.star": "who = \"override\"\n"}
+	chain := ChainLoader{overrides, dbLoader{"db.star": "x = 1\n"}, DirLoader{dir}}
+
+	src, v, err := chain.Load("lib.star")
+	if err != nil || !strings.Contains(string(src), "override") || v == "" {
+		t.Fatalf("Load(lib.star) = %q, %q, %v", src, v, err)
+	}
+	if src, v, err := chain.Load("disk.star"); err != nil || string(src) != "out = 1\n" || v != "" {
+		t.Fatalf("Load(disk.star) = %q, %q, %v", src, v, err)
+	}
+	if _, _, err := chain.Load("none.star"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected a not-found error, got %v", err)
+	}
+	if v, err := chain.Version("db.star"); err != nil || v != "" {
+		t.Fatalf("Version(db.star) = %q, %v", v, err)
+	}
+	names, err := chain.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(names) != "[disk.star lib.star]" {
+		t.Fatalf("List() = %v", names)
+	}
+
+	c, err := WithLoader(chain, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := c.Run("db.star", nil)
+	if err != nil || res["x"] != int64(1) {
+		t.Fatalf("Run(db.star) = %v, %v", res, err)
+	}
+	if err := c.Preload("*.star", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a loader that cannot list cannot back discovery
+	c, err = WithLoader(dbLoader{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Preload("", nil); err == nil || !strings.Contains(err.Error(), "cannot list scripts") {
+		t.Fatalf("expected a listing error, got %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package starlight
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/1set/starlight/convert"
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+)
+
+// RunOption configures a single Eval or Cache.Run call.
+type RunOption func(*runConfig)
+
+// runConfig is the per-run configuration assembled from RunOptions.
+type runConfig struct {
+	locals  map[string]interface{}
+	ctx     context.Context
+	profile io.Writer
+	types   *convert.TypeRegistry
+
+	maxSteps uint64
+
+	deterministic bool
+	clock         time.Time
+	seed          int64
+
+	filter resultFilter
+}
+
+// WithThreadLocal attaches a thread-local value to the Starlark thread of
+// the run (see starlark.Thread.SetLocal). Go functions bound into the script
+// can read it from the thread they are called on — a wrapped Go function
+// whose first parameter is a *starlark.Thread receives that thread.
+func WithThreadLocal(key string, value interface{}) RunOption {
+	return func(rc *runConfig) {
+		if rc.locals == nil {
+			rc.locals = make(map[string]interface{})
+		}
+		rc.locals[key] = value
+	}
+}
+
+// WithContext attaches ctx to the run: it is stored as the thread-local
+// convert.ContextKey, so wrapped Go functions whose first parameter is a
+// context.Context receive it, and the run is cancelled when ctx is done,
+// for loops over Go channels included (see convert.GoChan).
+func WithContext(ctx context.Context) RunOption {
+	return func(rc *runConfig) {
+		rc.ctx = ctx
+	}
+}
+
+// WithTracer reports every call the run makes to a wrapped Go function to
+// tracer, by storing it as the thread-local convert.TracerKey. It applies to
+// load()ed modules too in per-run globals mode.
+func WithTracer(tracer *convert.Tracer) RunOption {
+	return WithThreadLocal(convert.TracerKey, tracer)
+}
+
+// WithTypes makes the run consult the type conversions registered in r
+// before the global ones (see convert.TypeRegistry): the globals given to
+// the run are converted with r, and r is stored as the thread-local
+// convert.TypesKey for the wrapped Go functions the run calls. Set it with
+// SetRunOptions to scope conversions to the scripts of one Cache; the
+// globals of its load()ed modules (see WithGlobals) are converted once,
+// with the global conversions only.
+func WithTypes(r *convert.TypeRegistry) RunOption {
+	return func(rc *runConfig) {
+		rc.types = r
+	}
+}
+
+// WithDeterministic makes the run reproducible: time.now() of the Starlark
+// time module (go.starlark.net/lib/time) always returns clock,
+// convert.ThreadRand returns a source seeded with seed, and wrapped Go
+// functions not marked with convert.Deterministic fail when called. Wrapped
+// Go maps already iterate in sorted key order. It applies to load()ed
+// modules too in per-run globals mode.
+func WithDeterministic(clock time.Time, seed int64) RunOption {
+	return func(rc *runConfig) {
+		rc.deterministic = true
+		rc.clock = clock
+		rc.seed = seed
+	}
+}
+
+// WithMaxSteps cancels the run once a thread of it has executed more than
+// max Starlark computation steps (see starlark.Thread.SetMaxExecutionSteps).
+// The budget applies to each thread on its own: the main thread and, in
+// per-run globals mode, each thread executing a load()ed module.
+func WithMaxSteps(max uint64) RunOption {
+	return func(rc *runConfig) {
+		rc.maxSteps = max
+	}
+}
+
+func newRunConfig(opts []RunOption) *runConfig {
+	rc := &runConfig{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(rc)
+		}
+	}
+	return rc
+}
+
+// makeDict converts the globals of a run, with the conversions of the run.
+func (rc *runConfig) makeDict(globals map[string]interface{}) (starlark.StringDict, error) {
+	if rc.types != nil {
+		return rc.types.MakeStringDict(globals)
+	}
+	return convert.MakeStringDict(globals)
+}
+
+// makeTuple converts the arguments of a call, as makeDict does.
+func (rc *runConfig) makeTuple(args []interface{}) (starlark.Tuple, error) {
+	if rc.types == nil {
+		return convert.MakeTuple(args)
+	}
+	tuple := make(starlark.Tuple, len(args))
+	for i, v := range args {
+		sv, err := rc.types.ToValue(v)
+		if err != nil {
+			return nil, err
+		}
+		tuple[i] = sv
+	}
+	return tuple, nil
+}
+
+// start begins a run under this configuration. The returned runState
+// creates the threads of the run; call its stop (or finish) method once the
+// run has finished. It fails only if a requested profile cannot be started.
+func (rc *runConfig) start() (*runState, error) {
+	if rc.profile != nil {
+		if err := startProfile(rc.profile); err != nil {
+			return nil, fmt.Errorf("starlight: cannot start profile: %w", err)
+		}
+	}
+	rs := &runState{rc: rc, done: make(chan struct{})}
+	base := rc.ctx
+	if base == nil {
+		base = context.Background()
+	}
+	rs.ctx = base
+	if rc.deterministic {
+		// one source per run, shared by its threads, which run one at a time
+		rs.rand = rand.New(rand.NewSource(rc.seed))
+	}
+	if rc.ctx != nil && rc.ctx.Err() != nil {
+		rs.reason = rc.ctx.Err().Error()
+	} else if rc.ctx != nil && rc.ctx.Done() != nil {
+		go func() {
+			select {
+			case <-rc.ctx.Done():
+				rs.cancel(rc.ctx.Err().Error())
+			case <-rs.done:
+			}
+		}()
+	}
+	return rs, nil
+}
+
+// runState tracks the threads of one run — the main thread and, in
+// per-run globals mode, the threads executing load()ed modules — so that
+// cancelling the run's context cancels all of them.
+type runState struct {
+	rc       *runConfig
+	ctx      context.Context // the thread context
+	done     chan struct{}
+	stopOnce sync.Once
+	mu       sync.Mutex
+	threads  []*starlark.Thread
+	reason   string     // set once the run is cancelled
+	rand     *rand.Rand // the seeded source of a deterministic run
+}
+
+// newThread returns a thread for this run with the configured thread-locals
+// and load function.
+func (rs *runState) newThread(load LoadFunc) *starlark.Thread {
+	thread := &starlark.Thread{Load: load}
+	rs.setup(thread)
+	return thread
+}
+
+// setup applies the run configuration to thread and registers it for
+// cancellation.
+func (rs *runState) setup(thread *starlark.Thread) {
+	for k, v := range rs.rc.locals {
+		thread.SetLocal(k, v)
+	}
+	thread.SetLocal(convert.ContextKey, rs.ctx)
+	if rs.rc.types != nil {
+		thread.SetLocal(convert.TypesKey, rs.rc.types)
+	}
+	if rs.rc.maxSteps > 0 {
+		thread.SetMaxExecutionSteps(rs.rc.maxSteps)
+	}
+	if rs.rc.deterministic {
+		clock := rs.rc.clock
+		thread.SetLocal(convert.DeterministicKey, true)
+		thread.SetLocal(convert.RandKey, rs.rand)
+		startime.SetNow(thread, func() (time.Time, error) { return clock, nil })
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.reason != "" {
+		thread.Cancel(rs.reason)
+		return
+	}
+	rs.threads = append(rs.threads, thread)
+}
+
+// bindThread returns dict with its values bound to thread (see
+// convert.BindThread), so that for loops over the Go channels they hold end
+// with the run.
+func bindThread(dict starlark.StringDict, thread *starlark.Thread) starlark.StringDict {
+	bound := make(starlark.StringDict, len(dict))
+	for k, v := range dict {
+		bound[k] = convert.BindThread(v, thread)
+	}
+	return bound
+}
+
+func (rs *runState) cancel(reason string) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.reason = reason
+	for _, t := range rs.threads {
+		t.Cancel(reason)
+	}
+}
+
+// stop releases the context watcher of the run and, if the run is profiled,
+// stops the profiler and finalizes the profile.
+func (rs *runState) stop() (err error) {
+	rs.stopOnce.Do(func() {
+		close(rs.done)
+		if rs.rc.profile != nil {
+			if err = stopProfile(); err != nil {
+				err = fmt.Errorf("starlight: cannot write profile: %w", err)
+			}
+		}
+	})
+	return err
+}
+
+// finish stops the run, reporting a profile failure through *err unless the
+// run itself already failed. It is meant to be deferred.
+func (rs *runState) finish(err *error) {
+	if perr := rs.stop(); perr != nil && *err == nil {
+		*err = perr
+	}
+}
@@ -0,0 +1,320 @@
+package starlight
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1set/starlight/convert"
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+)
+
+type reqKey struct{}
+
+// TestWithThreadLocal verifies thread-locals reach Go callbacks through
+// both Eval and Cache.Run, including callbacks made by load()ed modules in
+// per-run globals mode.
+func TestWithThreadLocal(t *testing.T) {
+	globals := map[string]interface{}{
+		"tenant": func(th *starlark.Thread) string {
+			s, _ := th.Local("tenant").(string)
+			return s
+		},
+	}
+	res, err := Eval([]byte(`out = tenant()`), globals, nil, WithThreadLocal("tenant", "acme"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["out"] != "acme" {
+		t.Fatalf("Eval out = %v, want acme", res["out"])
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "mod.star"), []byte("t = tenant()\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.star"), []byte("load(\"mod.star\", \"t\")\nout = t + \"/\" + tenant()\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := New(dir)
+	c.SetModuleRunGlobals(true)
+	for _, tenant := range []string{"a", "b"} {
+		res, err := c.Run("main.star", globals, WithThreadLocal("tenant", tenant))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tenant + "/" + tenant; res["out"] != want {
+			t.Fatalf("Run out = %v, want %v", res["out"], want)
+		}
+	}
+}
+
+// TestWithContext verifies the run context reaches context.Context
+// parameters and cancels the run when done.
+func TestWithContext(t *testing.T) {
+	globals := map[string]interface{}{
+		"reqid": func(ctx context.Context) string {
+			s, _ := ctx.Value(reqKey{}).(string)
+			return s
+		},
+	}
+	ctx := context.WithValue(context.Background(), reqKey{}, "req-7")
+	res, err := Eval([]byte(`out = reqid()`), globals, nil, WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["out"] != "req-7" {
+		t.Fatalf("out = %v, want req-7", res["out"])
+	}
+
+	spin := []byte(`
+def spin():
+    n = 0
+    for i in range(1 << 40):
+        n += 1
+    return n
+out = spin()
+`)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = Eval(spin, nil, nil, WithContext(ctx))
+	if err == nil || !strings.Contains(err.Error(), "context deadline exceeded") {
+		t.Fatalf("expected a cancellation error, got %v", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("cancellation took %v", d)
+	}
+
+	// an already-cancelled context stops the run before it gets anywhere
+	done, cancel2 := context.WithCancel(context.Background())
+	cancel2()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "spin.star"), spin, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(dir).Run("spin.star", nil, WithContext(done)); err == nil || !strings.Contains(err.Error(), "context canceled") {
+		t.Fatalf("expected a cancellation error, got %v", err)
+	}
+
+	// for loops over Go channels end with the run
+	drain := []byte(`
+def drain(ch):
+    for x in ch:
+        pass
+drain(ch)
+`)
+	if err := os.WriteFile(filepath.Join(dir, "drain.star"), drain, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan int)
+	chans := map[string]interface{}{"ch": ch}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := Eval(drain, chans, nil, WithContext(ctx)); err == nil || !strings.Contains(err.Error(), "context deadline exceeded") {
+		t.Fatalf("expected a cancellation error, got %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := New(dir).Run("drain.star", chans, WithContext(ctx)); err == nil || !strings.Contains(err.Error(), "context deadline exceeded") {
+		t.Fatalf("expected a cancellation error, got %v", err)
+	}
+}
+
+// TestRunGlobalsShared verifies a Go wrapper given to a run is the one the
+// script mutates, so the host sees the changes.
+func TestRunGlobalsShared(t *testing.T) {
+	dir := writeScripts(t, map[string]string{"grow.star": "s.append(2)\ns.extend([3])\n"})
+	for _, run := range []func(map[string]interface{}) error{
+		func(g map[string]interface{}) error {
+			_, err := Eval([]byte("s.append(2)\ns.extend([3])\n"), g, nil, WithContext(context.Background()))
+			return err
+		},
+		func(g map[string]interface{}) error {
+			_, err := New(dir).Run("grow.star", g)
+			return err
+		},
+	} {
+		s := convert.NewGoSlice([]int{1})
+		if err := run(map[string]interface{}{"s": s}); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprint(s.Value().Interface()); got != "[1 2 3]" {
+			t.Errorf("host sees %s, want [1 2 3]", got)
+		}
+	}
+}
+
+// TestWithMaxSteps verifies a run is cancelled once it exceeds its step
+// budget, and a run within the budget is not.
+func TestWithMaxSteps(t *testing.T) {
+	src := []byte(`
+def count(n):
+    t = 0
+    for i in range(n):
+        t += i
+    return t
+out = count(limit)
+`)
+	res, err := Eval(src, map[string]interface{}{"limit": 10}, nil, WithMaxSteps(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["out"] != int64(45) {
+		t.Fatalf("out = %v, want 45", res["out"])
+	}
+	_, err = Eval(src, map[string]interface{}{"limit": 100000}, nil, WithMaxSteps(1000))
+	if err == nil || !strings.Contains(err.Error(), "too many steps") {
+		t.Fatalf("expected a step budget error, got %v", err)
+	}
+}
+
+// TestWithTracer verifies tracing set on a cache applies to its runs and
+// plugins, and per-run tracing leaves other runs untraced.
+func TestWithTracer(t *testing.T) {
+	var names []string
+	tracer := &convert.Tracer{
+		OnCall: func(_ *starlark.Thread, call *convert.CallTrace) {
+			names = append(names, fmt.Sprintf("%s@%s", call.Name, call.Position))
+		},
+	}
+	globals := map[string]interface{}{"lookup": strings.ToUpper}
+	dir := writeScripts(t, map[string]string{
+		"main.star":   "out = lookup(\"a\")\n",
+		"plugin.star": "def hook():\n    return lookup(\"b\")\n",
+	})
+
+	c := New(dir)
+	if _, err := c.Run("main.star", globals); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("untraced run reported %v", names)
+	}
+	if _, err := c.Run("main.star", globals, WithTracer(tracer)); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "lookup@main.star:1:13" {
+		t.Fatalf("traced run reported %v", names)
+	}
+
+	names = nil
+	c.SetRunOptions(WithTracer(tracer))
+	h, err := NewPluginHost(c, "plugin.star", globals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.Plugins()[0].Call("hook"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Run("main.star", globals); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"lookup@plugin.star:2:18", "lookup@main.star:1:13"}; fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Fatalf("cache-wide tracing reported %v, want %v", names, want)
+	}
+}
+
+type level int
+
+type levelCfg struct {
+	Level level `starlark:"level"`
+}
+
+// TestWithTypes verifies the type conversions set on a cache apply to its
+// runs only.
+func TestWithTypes(t *testing.T) {
+	r := convert.NewTypeRegistry()
+	r.RegisterType(reflect.TypeOf(level(0)),
+		func(v reflect.Value) (starlark.Value, error) {
+			return starlark.String(fmt.Sprintf("L%d", v.Int())), nil
+		},
+		func(v starlark.Value) (reflect.Value, error) {
+			var l level
+			s, _ := starlark.AsString(v)
+			if _, err := fmt.Sscanf(s, "L%d", &l); err != nil {
+				return reflect.Value{}, fmt.Errorf("bad level %q", s)
+			}
+			return reflect.ValueOf(l), nil
+		})
+	globals := func() map[string]interface{} {
+		return map[string]interface{}{
+			"cfg":  &levelCfg{Level: 5},
+			"next": func(l level) level { return l + 1 },
+		}
+	}
+	dir := writeScripts(t, map[string]string{
+		"scoped.star": "cur = cfg.level\nout = next(\"L1\")\n",
+		"plain.star":  "cur = cfg.level\nout = next(1)\n",
+	})
+
+	scoped := New(dir)
+	scoped.SetRunOptions(WithTypes(r))
+	res, err := scoped.Run("scoped.star", globals())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["cur"] != "L5" || res["out"] != "L2" {
+		t.Fatalf("scoped run = %v, %v", res["cur"], res["out"])
+	}
+
+	res, err = New(dir).Run("plain.star", globals())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["cur"] != int64(5) || res["out"] != int64(2) {
+		t.Fatalf("plain run = %v, %v", res["cur"], res["out"])
+	}
+	if _, err := New(dir).Run("scoped.star", globals()); err == nil {
+		t.Fatal("plain cache used the conversions of another cache")
+	}
+}
+
+// TestWithDeterministic verifies deterministic runs see a fixed clock and a
+// seeded random source, replay identically, and reject unmarked functions.
+func TestWithDeterministic(t *testing.T) {
+	clock := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	globals := map[string]interface{}{
+		"time": startime.Module,
+		"roll": convert.Deterministic(func(th *starlark.Thread) int { return convert.ThreadRand(th).Intn(1000) }),
+		"now":  time.Now,
+	}
+	dir := writeScripts(t, map[string]string{
+		"main.star": "stamp = str(time.now())\nrolls = [roll(), roll(), roll()]\n",
+		"bad.star":  "t = now()\n",
+	})
+	c := New(dir)
+	c.SetRunOptions(WithDeterministic(clock, 42))
+	first, err := c.Run("main.star", globals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first["stamp"] != "2024-01-02 03:04:05 +0000 UTC" {
+		t.Fatalf("stamp = %v", first["stamp"])
+	}
+	second, err := c.Run("main.star", globals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(first["rolls"]) != fmt.Sprint(second["rolls"]) {
+		t.Fatalf("rolls differ: %v vs %v", first["rolls"], second["rolls"])
+	}
+	if _, err := c.Run("bad.star", globals); err == nil || !strings.Contains(err.Error(), "now: not allowed in a deterministic run") {
+		t.Fatalf("expected rejection, got %v", err)
+	}
+
+	// without the option the clock is real and unmarked functions run
+	res, err := Eval([]byte("t = now()\n"), globals, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res["t"].(time.Time); !ok {
+		t.Fatalf("t = %T", res["t"])
+	}
+}
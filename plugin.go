@@ -0,0 +1,401 @@
+package starlight
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/1set/starlight/convert"
+	"go.starlark.net/starlark"
+)
+
+// PluginManifestGlobal is the name of the script global a plugin may define
+// to describe itself, e.g.
+//
+//	PLUGIN = {"name": "greeter", "version": "1.2.0", "hooks": {"greet": 1}}
+const PluginManifestGlobal = "PLUGIN"
+
+// pluginSidecarExt is the extension of the sidecar manifest file read when
+// a script defines no PluginManifestGlobal: greet.star -> greet.plugin.json.
+const pluginSidecarExt = ".plugin.json"
+
+// AnyArity declares a hook whose arity is not checked.
+const AnyArity = -1
+
+// PluginManifest describes a plugin script. Hooks maps each declared
+// entrypoint function to the number of positional arguments it is called
+// with, or AnyArity. In a manifest, hooks is either such a dict or a list
+// of hook names (all AnyArity).
+type PluginManifest struct {
+	Name    string
+	Version string
+	Hooks   map[string]int
+}
+
+// Plugin is a script discovered by a PluginHost.
+type Plugin struct {
+	_        convert.DoNotCompare
+	cache    *Cache
+	file     string
+	manifest PluginManifest
+	globals  starlark.StringDict
+	disabled int32 // accessed atomically
+}
+
+// PluginHost treats the scripts in a Cache's directories as plugins: it
+// runs each of them once, reads their manifests, validates the declared
+// hooks, and dispatches hook calls to enabled plugins.
+type PluginHost struct {
+	_       convert.DoNotCompare
+	cache   *Cache
+	plugins []*Plugin // sorted by name
+	byName  map[string]*Plugin
+}
+
+// NewPluginHost discovers every script matching pattern ("" means "*.star")
+// the cache's loader lists (see Lister), and runs it with
+// globals as a plugin. The pattern uses path.Match syntax and is matched
+// against the script's base name, or against its slash-separated path
+// (relative to its directory, for a DirLoader) if the pattern contains a
+// slash.
+//
+// A plugin's manifest is its PLUGIN global if defined, else the sidecar file
+// with the script's extension replaced by ".plugin.json" (a JSON object with
+// the same keys), else a default manifest named after the script with no
+// declared hooks. Every declared hook must be a function of the script that
+// accepts its declared number of positional arguments, and plugin names
+// must be unique; otherwise NewPluginHost fails with an error listing every
+// problem found.
+func NewPluginHost(c *Cache, pattern string, globals map[string]interface{}) (*PluginHost, error) {
+	if pattern == "" {
+		pattern = "*.star"
+	}
+	dict, err := convert.MakeStringDict(globals)
+	if err != nil {
+		return nil, err
+	}
+	files, err := c.listScripts(pattern)
+	if err != nil {
+		return nil, err
+	}
+	h := &PluginHost{cache: c, byName: make(map[string]*Plugin)}
+	var problems []string
+	for _, file := range files {
+		if strings.HasSuffix(file, pluginSidecarExt) {
+			continue
+		}
+		p, err := h.loadPlugin(file, dict)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", file, err))
+			continue
+		}
+		if prev, ok := h.byName[p.manifest.Name]; ok {
+			problems = append(problems, fmt.Sprintf("%s: plugin name %q already used by %s", file, p.manifest.Name, prev.file))
+			continue
+		}
+		h.byName[p.manifest.Name] = p
+		h.plugins = append(h.plugins, p)
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("starlight: invalid plugins:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	sort.Slice(h.plugins, func(i, j int) bool { return h.plugins[i].manifest.Name < h.plugins[j].manifest.Name })
+	return h, nil
+}
+
+// loadPlugin runs one plugin script and validates its manifest.
+func (h *PluginHost) loadPlugin(file string, dict starlark.StringDict) (*Plugin, error) {
+	ret, err := h.cache.exec(file, dict, h.cache.runConfig(nil))
+	if err != nil {
+		return nil, err
+	}
+	// hooks may run concurrently; frozen globals make that safe
+	ret.Freeze()
+
+	var m PluginManifest
+	if v, ok := ret[PluginManifestGlobal]; ok {
+		m, err = manifestFromValue(v)
+	} else if b, rerr := h.cache.readFile(strings.TrimSuffix(file, path.Ext(file)) + pluginSidecarExt); rerr == nil {
+		m, err = manifestFromJSON(b)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("manifest: %v", err)
+	}
+	if m.Name == "" {
+		m.Name = strings.TrimSuffix(path.Base(file), path.Ext(file))
+	}
+	for _, hook := range sortedHooks(m.Hooks) {
+		if err := checkHook(ret, hook, m.Hooks[hook]); err != nil {
+			return nil, err
+		}
+	}
+	return &Plugin{cache: h.cache, file: file, manifest: m, globals: ret}, nil
+}
+
+// manifestFromValue reads a manifest from the script's PLUGIN global.
+func manifestFromValue(v starlark.Value) (PluginManifest, error) {
+	d, ok := v.(*starlark.Dict)
+	if !ok {
+		return PluginManifest{}, fmt.Errorf("%s must be a dict, got %s", PluginManifestGlobal, v.Type())
+	}
+	raw := make(map[string]interface{}, d.Len())
+	for _, item := range d.Items() {
+		k, ok := starlark.AsString(item[0])
+		if !ok {
+			return PluginManifest{}, fmt.Errorf("%s key %s is not a string", PluginManifestGlobal, item[0])
+		}
+		raw[k] = convert.FromValue(item[1])
+	}
+	return manifestFromMap(raw)
+}
+
+// manifestFromJSON reads a manifest from a sidecar file.
+func manifestFromJSON(b []byte) (PluginManifest, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return PluginManifest{}, err
+	}
+	return manifestFromMap(raw)
+}
+
+// manifestFromMap reads the manifest keys out of their generic form, as
+// produced by either convert.FromValue or encoding/json.
+func manifestFromMap(raw map[string]interface{}) (PluginManifest, error) {
+	var m PluginManifest
+	for k, v := range raw {
+		switch k {
+		case "name", "version":
+			s, ok := v.(string)
+			if !ok {
+				return m, fmt.Errorf("%s must be a string, got %T", k, v)
+			}
+			if k == "name" {
+				m.Name = s
+			} else {
+				m.Version = s
+			}
+		case "hooks":
+			hooks, err := hooksFromValue(v)
+			if err != nil {
+				return m, err
+			}
+			m.Hooks = hooks
+		default:
+			return m, fmt.Errorf("unknown key %q", k)
+		}
+	}
+	return m, nil
+}
+
+// hooksFromValue reads the hooks entry of a manifest: a list of names or
+// a mapping of names to arities.
+func hooksFromValue(v interface{}) (map[string]int, error) {
+	hooks := make(map[string]int)
+	switch v := v.(type) {
+	case []interface{}:
+		for _, n := range v {
+			s, ok := n.(string)
+			if !ok {
+				return nil, fmt.Errorf("hook name must be a string, got %T", n)
+			}
+			hooks[s] = AnyArity
+		}
+	case map[interface{}]interface{}:
+		for n, a := range v {
+			s, ok := n.(string)
+			if !ok {
+				return nil, fmt.Errorf("hook name must be a string, got %T", n)
+			}
+			arity, err := hookArity(s, a)
+			if err != nil {
+				return nil, err
+			}
+			hooks[s] = arity
+		}
+	case map[string]interface{}:
+		for s, a := range v {
+			arity, err := hookArity(s, a)
+			if err != nil {
+				return nil, err
+			}
+			hooks[s] = arity
+		}
+	default:
+		return nil, fmt.Errorf("hooks must be a list or a dict, got %T", v)
+	}
+	return hooks, nil
+}
+
+// hookArity reads a declared arity: an int from Starlark, or a whole
+// float64 from JSON.
+func hookArity(name string, v interface{}) (int, error) {
+	switch a := v.(type) {
+	case int64:
+		if a >= AnyArity {
+			return int(a), nil
+		}
+	case float64:
+		if a >= AnyArity && a == float64(int(a)) {
+			return int(a), nil
+		}
+	}
+	return 0, fmt.Errorf("hook %q: arity must be an integer >= %d, got %v", name, AnyArity, v)
+}
+
+// checkHook verifies that the global hook is callable with arity
+// positional arguments. Only Starlark functions expose their signature;
+// other callables are accepted as long as they are callable.
+func checkHook(globals starlark.StringDict, hook string, arity int) error {
+	v, ok := globals[hook]
+	if !ok {
+		return fmt.Errorf("declared hook %q is not defined", hook)
+	}
+	if _, ok := v.(starlark.Callable); !ok {
+		return fmt.Errorf("declared hook %q is a %s, not a function", hook, v.Type())
+	}
+	fn, ok := v.(*starlark.Function)
+	if !ok || arity == AnyArity {
+		return nil
+	}
+	positional := fn.NumParams() - fn.NumKwonlyParams()
+	if fn.HasVarargs() {
+		positional--
+	}
+	if fn.HasKwargs() {
+		positional--
+	}
+	required := 0
+	for i := 0; i < positional; i++ {
+		if fn.ParamDefault(i) == nil {
+			required++
+		}
+	}
+	for i := positional; i < positional+fn.NumKwonlyParams(); i++ {
+		if fn.ParamDefault(i) == nil {
+			return fmt.Errorf("declared hook %q has a required keyword-only parameter", hook)
+		}
+	}
+	if arity < required || (arity > positional && !fn.HasVarargs()) {
+		return fmt.Errorf("declared hook %q takes %d arguments, but %s accepts %s", hook, arity, fn.Name(), describeArity(required, positional, fn.HasVarargs()))
+	}
+	return nil
+}
+
+func describeArity(required, positional int, varargs bool) string {
+	switch {
+	case varargs:
+		return fmt.Sprintf("at least %d", required)
+	case required == positional:
+		return fmt.Sprintf("exactly %d", required)
+	default:
+		return fmt.Sprintf("%d to %d", required, positional)
+	}
+}
+
+func sortedHooks(hooks map[string]int) []string {
+	names := make([]string, 0, len(hooks))
+	for n := range hooks {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Plugins returns all plugins, enabled or not, sorted by name.
+func (h *PluginHost) Plugins() []*Plugin {
+	return append([]*Plugin(nil), h.plugins...)
+}
+
+// Plugin returns the plugin with the given name, or nil if there is none.
+func (h *PluginHost) Plugin(name string) *Plugin {
+	return h.byName[name]
+}
+
+// Name returns the plugin name from its manifest.
+func (p *Plugin) Name() string {
+	return p.manifest.Name
+}
+
+// File returns the script name of the plugin, as the cache's loader knows
+// it.
+func (p *Plugin) File() string {
+	return p.file
+}
+
+// Manifest returns a copy of the plugin manifest.
+func (p *Plugin) Manifest() PluginManifest {
+	m := p.manifest
+	if m.Hooks != nil {
+		m.Hooks = make(map[string]int, len(p.manifest.Hooks))
+		for k, v := range p.manifest.Hooks {
+			m.Hooks[k] = v
+		}
+	}
+	return m
+}
+
+// Enabled reports whether hook calls are dispatched to the plugin.
+// Plugins start enabled.
+func (p *Plugin) Enabled() bool {
+	return atomic.LoadInt32(&p.disabled) == 0
+}
+
+// Enable lets Call dispatch hook calls to the plugin again.
+func (p *Plugin) Enable() {
+	atomic.StoreInt32(&p.disabled, 0)
+}
+
+// Disable makes Call fail for the plugin until it is enabled again.
+func (p *Plugin) Disable() {
+	atomic.StoreInt32(&p.disabled, 1)
+}
+
+// Call calls the hook function of the plugin with the given arguments,
+// converted as by convert.ToValue, and returns its result converted as by
+// convert.FromValue. If the manifest declares hooks, only those can be
+// called; otherwise any public (not underscore-prefixed) function of the
+// script can.
+func (p *Plugin) Call(hook string, args ...interface{}) (interface{}, error) {
+	return p.CallWith(nil, hook, args...)
+}
+
+// CallWith is like Call, but runs the hook on a thread configured by opts,
+// which apply after the run options of the cache (see Cache.SetRunOptions).
+func (p *Plugin) CallWith(opts []RunOption, hook string, args ...interface{}) (_ interface{}, err error) {
+	if !p.Enabled() {
+		return nil, fmt.Errorf("plugin %q is disabled", p.manifest.Name)
+	}
+	if p.manifest.Hooks != nil {
+		if _, ok := p.manifest.Hooks[hook]; !ok {
+			return nil, fmt.Errorf("plugin %q declares no hook %q", p.manifest.Name, hook)
+		}
+	} else if strings.HasPrefix(hook, "_") {
+		return nil, fmt.Errorf("plugin %q: hook %q is private", p.manifest.Name, hook)
+	}
+	fn, ok := p.globals[hook].(starlark.Callable)
+	if !ok {
+		return nil, fmt.Errorf("plugin %q has no function %q", p.manifest.Name, hook)
+	}
+	rc := p.cache.runConfig(opts)
+	tuple, err := rc.makeTuple(args)
+	if err != nil {
+		return nil, err
+	}
+	rs, err := rc.start()
+	if err != nil {
+		return nil, err
+	}
+	defer rs.finish(&err)
+	thread := rs.newThread(nil)
+	for i, v := range tuple {
+		tuple[i] = convert.BindThread(v, thread)
+	}
+	ret, err := starlark.Call(thread, fn, tuple, nil)
+	if err != nil {
+		return nil, err
+	}
+	return convert.FromValue(ret), nil
+}
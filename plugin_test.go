@@ -0,0 +1,175 @@
+package starlight
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeScripts writes name -> source files into a new temporary directory.
+func writeScripts(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range files {
+		full := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(full), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestPluginHost(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"greeter.star": `
+PLUGIN = {"name": "greeter", "version": "1.0.0", "hooks": {"greet": 1, "count": 0}}
+def greet(name, punct="!"):
+    return prefix + name + punct
+def count():
+    return 2
+`,
+		"sub/shout.star": `
+def shout(s):
+    return s.upper()
+def _helper():
+    return None
+`,
+		"sub/shout.plugin.json": `{"version": "0.1"}`,
+		"sidecar.star":          "def run(*args):\n    return len(args)\n",
+		"sidecar.plugin.json":   `{"name": "side", "hooks": ["run"]}`,
+		"notes.txt":             "not a script",
+	})
+	h, err := NewPluginHost(New(dir), "", map[string]interface{}{"prefix": "hi "})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, p := range h.Plugins() {
+		names = append(names, p.Name())
+	}
+	if want := []string{"greeter", "shout", "side"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("plugins = %v, want %v", names, want)
+	}
+	if m := h.Plugin("greeter").Manifest(); m.Version != "1.0.0" || m.Hooks["greet"] != 1 {
+		t.Fatalf("greeter manifest = %+v", m)
+	}
+	if m := h.Plugin("shout").Manifest(); m.Version != "0.1" || m.Hooks != nil {
+		t.Fatalf("shout manifest = %+v", m)
+	}
+	if f := h.Plugin("shout").File(); f != "sub/shout.star" {
+		t.Fatalf("shout file = %q", f)
+	}
+	if h.Plugin("missing") != nil {
+		t.Fatal("expected nil for an unknown plugin")
+	}
+
+	res, err := h.Plugin("greeter").Call("greet", "bob")
+	if err != nil || res != "hi bob!" {
+		t.Fatalf("greet = %v, %v", res, err)
+	}
+	res, err = h.Plugin("side").Call("run", 1, 2, 3)
+	if err != nil || res != int64(3) {
+		t.Fatalf("run = %v, %v", res, err)
+	}
+	// no declared hooks: any public function is callable
+	res, err = h.Plugin("shout").Call("shout", "hey")
+	if err != nil || res != "HEY" {
+		t.Fatalf("shout = %v, %v", res, err)
+	}
+
+	for _, c := range []struct {
+		plugin, hook, want string
+	}{
+		{"greeter", "nope", `declares no hook "nope"`},
+		{"shout", "_helper", "is private"},
+		{"shout", "missing", `has no function "missing"`},
+	} {
+		if _, err := h.Plugin(c.plugin).Call(c.hook); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s.%s: expected error containing %q, got %v", c.plugin, c.hook, c.want, err)
+		}
+	}
+
+	// enable / disable
+	p := h.Plugin("greeter")
+	p.Disable()
+	if p.Enabled() {
+		t.Fatal("expected plugin to be disabled")
+	}
+	if _, err := p.Call("greet", "bob"); err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Fatalf("expected disabled error, got %v", err)
+	}
+	p.Enable()
+	if _, err := p.Call("greet", "bob"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPluginHostValidation(t *testing.T) {
+	cases := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"missing hook", map[string]string{
+			"a.star": `PLUGIN = {"hooks": ["run"]}`,
+		}, `declared hook "run" is not defined`},
+		{"not callable", map[string]string{
+			"a.star": "PLUGIN = {\"hooks\": [\"run\"]}\nrun = 1\n",
+		}, `declared hook "run" is a int, not a function`},
+		{"too many args", map[string]string{
+			"a.star": "PLUGIN = {\"hooks\": {\"run\": 2}}\ndef run(x):\n    pass\n",
+		}, `takes 2 arguments, but run accepts exactly 1`},
+		{"too few args", map[string]string{
+			"a.star": "PLUGIN = {\"hooks\": {\"run\": 0}}\ndef run(x, y=1):\n    pass\n",
+		}, `takes 0 arguments, but run accepts 1 to 2`},
+		{"kwonly", map[string]string{
+			"a.star": "PLUGIN = {\"hooks\": {\"run\": 0}}\ndef run(*, x):\n    pass\n",
+		}, `required keyword-only parameter`},
+		{"bad manifest", map[string]string{
+			"a.star": `PLUGIN = {"nmae": "x"}`,
+		}, `unknown key "nmae"`},
+		{"bad sidecar", map[string]string{
+			"a.star":        "x = 1\n",
+			"a.plugin.json": `{"hooks": 3}`,
+		}, `hooks must be a list or a dict`},
+		{"duplicate name", map[string]string{
+			"a.star": `PLUGIN = {"name": "x"}`,
+			"b.star": `PLUGIN = {"name": "x"}`,
+		}, `plugin name "x" already used by a.star`},
+		{"script error", map[string]string{
+			"a.star": `x = 1 +`,
+		}, `a.star:`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dir := writeScripts(t, c.files)
+			_, err := NewPluginHost(New(dir), "*.star", nil)
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Fatalf("expected error containing %q, got %v", c.want, err)
+			}
+		})
+	}
+}
+
+// TestPluginHostPattern verifies patterns with a slash match the relative
+// path rather than the base name.
+func TestPluginHostPattern(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"top.star":          "def f():\n    return 1\n",
+		"plugins/p1.star":   "def f():\n    return 2\n",
+		"plugins/x/p2.star": "def f():\n    return 3\n",
+	})
+	h, err := NewPluginHost(New(dir), "plugins/*.star", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ps := h.Plugins(); len(ps) != 1 || ps[0].Name() != "p1" {
+		t.Fatalf("plugins = %v", ps)
+	}
+}
@@ -0,0 +1,58 @@
+package starlight
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestPreload verifies Preload reports every broken script with positions
+// and warms the program cache for the good ones.
+func TestPreload(t *testing.T) {
+	dir := writeScripts(t, map[string]string{
+		"good.star":         "out = greeting + \"!\"\n",
+		"sub/nested.star":   "out = greeting * 2\n",
+		"syntax.star":       "x = (\n",
+		"resolve.star":      "a = missing1\nb = missing2\n",
+		"notes.txt":         "not a script (\n",
+		"sub/resolve2.star": "c = greeting + nope\n",
+	})
+	c := New(dir)
+	err := c.Preload("", []string{"greeting"})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		"syntax.star:2:1: got end of file, want primary expression",
+		"resolve.star:1:5: undefined: missing1",
+		"resolve.star:2:5: undefined: missing2",
+		"sub/resolve2.star:1:16: undefined: nope",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	for _, unwanted := range []string{"good.star", "nested.star", "notes.txt"} {
+		if strings.Contains(err.Error(), unwanted) {
+			t.Errorf("error mentions %s: %v", unwanted, err)
+		}
+	}
+
+	// the good scripts run from the cache, even once their files are gone
+	for _, f := range []string{"good.star", "sub/nested.star"} {
+		if err := os.Remove(filepath.Join(dir, filepath.FromSlash(f))); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Run(f, map[string]interface{}{"greeting": "hi"}); err != nil {
+			t.Errorf("%s: %v", f, err)
+		}
+	}
+
+	if err := c.Preload("good*.star", nil); err != nil {
+		t.Errorf("preloading no files: %v", err)
+	}
+	if err := New(dir).Preload("[", nil); err == nil {
+		t.Error("expected a bad pattern error")
+	}
+}
@@ -0,0 +1,89 @@
+package starlight
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+// The interpreter's profiler (starlark.StartProfile) is process-wide: once
+// started it samples every Starlark thread, and it must not be started or
+// stopped while Starlark code runs — stopping it closes the channel running
+// threads send their samples to. Starlight runs at most one profiling
+// session at a time and fails a session requested while another runs,
+// rather than making it wait; keeping other Starlark executions clear of
+// the start and stop of a session is up to the host.
+
+var (
+	// profileMu guards profiling, which is set for the whole lifetime of a
+	// profiling session.
+	profileMu sync.Mutex
+	profiling bool
+
+	errProfiling = errors.New("another profiling session is running")
+)
+
+// WithProfile captures a pprof-compatible profile of the run into w, using
+// the interpreter's built-in profiler: frames carry the script filenames and
+// lines, so `go tool pprof` shows the Starlark call stacks. The profile is
+// complete when the run returns, and the profiler is stopped whether the run
+// succeeds or not.
+//
+// Only one profiling session runs at a time: a profiled run started while
+// another session runs (including a profiled run nested in it) fails with
+// an error. Runs without WithProfile never wait for a session. Because the
+// profiler is process-wide, executions that overlap the profiled run are
+// sampled into its profile too; they must not be running when the profiled
+// run starts or returns, as the profiler is switched on and off then.
+func WithProfile(w io.Writer) RunOption {
+	return func(rc *runConfig) {
+		rc.profile = w
+	}
+}
+
+// ProfileWindow profiles every starlight execution for the duration d, or
+// until ctx is done, and writes a pprof-compatible profile into w. It is a
+// profiling session like a WithProfile run, and fails the same way if
+// another session is running. Executions must not be running when the
+// window opens or closes (see WithProfile).
+func ProfileWindow(ctx context.Context, w io.Writer, d time.Duration) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := startProfile(w); err != nil {
+		return err
+	}
+	t := time.NewTimer(d)
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		t.Stop()
+	}
+	return stopProfile()
+}
+
+// startProfile begins a profiling session writing into w.
+func startProfile(w io.Writer) error {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	if profiling {
+		return errProfiling
+	}
+	if err := starlark.StartProfile(w); err != nil {
+		return err
+	}
+	profiling = true
+	return nil
+}
+
+// stopProfile ends the current profiling session and finalizes its profile.
+func stopProfile() error {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	profiling = false
+	return starlark.StopProfile()
+}
@@ -0,0 +1,249 @@
+package starlight
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.starlark.net/starlark"
+)
+
+const spinScript = `
+def spin(n):
+    total = 0
+    for i in range(n):
+        total += i * i
+    return total
+
+out = spin(loops)
+`
+
+// profileText decompresses a profile so tests can look for the frame names
+// in its string table.
+func profileText(t *testing.T, b []byte) string {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("profile is not gzipped: %v", err)
+	}
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+// profilerActive reports whether a profiling session is running.
+func profilerActive() bool {
+	profileMu.Lock()
+	defer profileMu.Unlock()
+	return profiling
+}
+
+// checkProfilerStopped fails if the global profiler was left running.
+func checkProfilerStopped(t *testing.T) {
+	t.Helper()
+	if err := starlark.StartProfile(io.Discard); err != nil {
+		t.Fatalf("profiler left running: %v", err)
+	}
+	if err := starlark.StopProfile(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestWithProfile verifies a profiled run produces a pprof profile naming
+// the script and its functions, and stops the profiler afterwards.
+func TestWithProfile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "prof.star"), []byte(spinScript), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := New(dir)
+	var buf bytes.Buffer
+	res, err := c.Run("prof.star", map[string]interface{}{"loops": 100000}, WithProfile(&buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["out"] == nil {
+		t.Fatal("missing result")
+	}
+	text := profileText(t, buf.Bytes())
+	for _, want := range []string{"prof.star", "spin"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("profile does not mention %q", want)
+		}
+	}
+	checkProfilerStopped(t)
+
+	buf.Reset()
+	if _, err := Eval([]byte(spinScript), map[string]interface{}{"loops": 100000}, nil, WithProfile(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(profileText(t, buf.Bytes()), "spin") {
+		t.Error("Eval profile does not mention spin")
+	}
+	checkProfilerStopped(t)
+}
+
+// TestWithProfileErrors verifies the profiler is stopped when the run fails
+// and that a profile which cannot be started fails the run.
+func TestWithProfileErrors(t *testing.T) {
+	var buf bytes.Buffer
+	_, err := Eval([]byte("def f():\n    fail(\"boom\")\nf()\n"), nil, nil, WithProfile(&buf))
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("expected script error, got %v", err)
+	}
+	checkProfilerStopped(t)
+
+	_, err = Eval([]byte("x = ("), nil, nil, WithProfile(&buf))
+	if err == nil {
+		t.Fatal("expected syntax error")
+	}
+	checkProfilerStopped(t)
+
+	// a run profiled while another session runs fails right away
+	if err := startProfile(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Eval([]byte("x = 1"), nil, nil, WithProfile(&buf))
+	if serr := stopProfile(); serr != nil {
+		t.Fatal(serr)
+	}
+	if !errors.Is(err, errProfiling) {
+		t.Fatalf("expected a busy profiler error, got %v", err)
+	}
+	checkProfilerStopped(t)
+}
+
+// TestWithProfileConcurrent verifies concurrent profiled runs either get
+// their own complete profile or fail, without waiting for each other.
+func TestWithProfileConcurrent(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "prof.star"), []byte(spinScript), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := New(dir)
+	const n = 6
+	bufs := make([]bytes.Buffer, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = c.Run("prof.star", map[string]interface{}{"loops": 100000}, WithProfile(&bufs[i]))
+		}(i)
+	}
+	wg.Wait()
+	profiled := 0
+	for i := 0; i < n; i++ {
+		switch {
+		case errors.Is(errs[i], errProfiling):
+		case errs[i] != nil:
+			t.Fatalf("run %d: %v", i, errs[i])
+		case !strings.Contains(profileText(t, bufs[i].Bytes()), "prof.star"):
+			t.Errorf("run %d: profile does not mention prof.star", i)
+		default:
+			profiled++
+		}
+	}
+	if profiled == 0 {
+		t.Error("no run was profiled")
+	}
+	checkProfilerStopped(t)
+}
+
+// TestProfileWindow verifies a sampling window captures the runs made
+// during it.
+func TestProfileWindow(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "prof.star"), []byte(spinScript), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := New(dir)
+	var buf bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- ProfileWindow(ctx, &buf, time.Hour) }()
+	// run only while the window is open, as the profiler must not be
+	// switched while Starlark code runs
+	for !profilerActive() {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Run("prof.star", map[string]interface{}{"loops": 100000}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(profileText(t, buf.Bytes()), "prof.star") {
+		t.Error("window profile does not mention prof.star")
+	}
+	checkProfilerStopped(t)
+
+	// a cancelled context closes the window early
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	buf.Reset()
+	start := time.Now()
+	if err := ProfileWindow(ctx, &buf, time.Hour); err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Error("cancelled window did not close early")
+	}
+	checkProfilerStopped(t)
+}
+
+// TestProfileNested verifies runs nested in a profiled run do not wait
+// for its session: unprofiled ones run, and profiled ones and windows fail.
+func TestProfileNested(t *testing.T) {
+	globals := map[string]interface{}{
+		"plain": func() (int64, error) {
+			res, err := Eval([]byte("x = 2"), nil, nil)
+			if err != nil {
+				return 0, err
+			}
+			return res["x"].(int64), nil
+		},
+		"profiled": func() error {
+			_, err := Eval([]byte("x = 2"), nil, nil, WithProfile(io.Discard))
+			return err
+		},
+		"window": func() error {
+			return ProfileWindow(context.Background(), io.Discard, time.Millisecond)
+		},
+	}
+	done := make(chan error, 1)
+	var buf bytes.Buffer
+	go func() {
+		_, err := Eval([]byte("y = plain()"), globals, nil, WithProfile(&buf))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("nested run waited for the session of its caller")
+	}
+	for _, src := range []string{"profiled()", "window()"} {
+		_, err := Eval([]byte(src), globals, nil, WithProfile(io.Discard))
+		if !errors.Is(err, errProfiling) {
+			t.Errorf("nested %s = %v, want a busy profiler error", src, err)
+		}
+	}
+	checkProfilerStopped(t)
+}
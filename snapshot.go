@@ -0,0 +1,405 @@
+package starlight
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/1set/starlight/convert"
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+)
+
+// snapshotVersion is the version of the SnapshotGlobals format.
+const snapshotVersion = 1
+
+// snapshot is the JSON document written by SnapshotGlobals.
+type snapshot struct {
+	Version int                        `json:"version"`
+	Globals map[string]json.RawMessage `json:"globals"`
+}
+
+// SnapshotGlobals serializes the globals of a script to JSON, so they can be
+// persisted and fed back into a later run with RestoreGlobals. It supports
+// None, bools, strings, ints of any size, floats (NaN and infinities
+// included), bytes, lists, tuples, dicts, sets, and the time and duration
+// values of go.starlark.net/lib/time. JSON null, bools, strings and arrays
+// hold None, bools, strings and lists; every other type is written as a
+// one-key object naming it (e.g. {"tuple": [...]}, {"int": "123"}), so
+// tuples, bytes and big ints come back with their types intact.
+//
+// Go values wrapped by starlight are written as scripts see them — structs
+// as dicts of their fields, keyed by the names scripts use for them, maps
+// as dicts, slices and arrays as lists — and restored as these Starlark
+// dicts, lists and scalars, since their Go types are not recorded. Values
+// that cannot be serialized, such as functions, modules or cyclic
+// containers, are all reported in the returned error by their path, e.g.
+// handlers["x"][0].
+func SnapshotGlobals(dict starlark.StringDict) ([]byte, error) {
+	s := snapshot{Version: snapshotVersion, Globals: make(map[string]json.RawMessage, len(dict))}
+	var problems []string
+	for _, name := range dict.Keys() {
+		e := &snapEncoder{visited: make(map[interface{}]bool)}
+		v := e.encode(dict[name], name)
+		if len(e.problems) > 0 {
+			problems = append(problems, e.problems...)
+			continue
+		}
+		raw, err := json.Marshal(v)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		s.Globals[name] = raw
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("starlight: cannot snapshot globals:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return json.Marshal(s)
+}
+
+// RestoreGlobals decodes globals written by SnapshotGlobals. The restored
+// values are not frozen. Starlark values pass through the conversion of run
+// globals unchanged, so copying the result into a map[string]interface{}
+// feeds it to a later Eval or Cache.Run as is.
+func RestoreGlobals(data []byte) (starlark.StringDict, error) {
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("starlight: cannot restore globals: %v", err)
+	}
+	if s.Version != snapshotVersion {
+		return nil, fmt.Errorf("starlight: cannot restore globals: unsupported snapshot version %d", s.Version)
+	}
+	dict := make(starlark.StringDict, len(s.Globals))
+	for name, raw := range s.Globals {
+		d := json.NewDecoder(bytes.NewReader(raw))
+		d.UseNumber()
+		var v interface{}
+		if err := d.Decode(&v); err != nil {
+			return nil, fmt.Errorf("starlight: cannot restore global %q: %v", name, err)
+		}
+		val, err := decodeSnapValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("starlight: cannot restore global %q: %v", name, err)
+		}
+		dict[name] = val
+	}
+	return dict, nil
+}
+
+// snapEncoder turns Starlark values into the JSON tree of a snapshot,
+// collecting every value it cannot encode.
+type snapEncoder struct {
+	visited  map[interface{}]bool // containers on the current path
+	problems []string
+}
+
+func (e *snapEncoder) fail(path, format string, args ...interface{}) interface{} {
+	e.problems = append(e.problems, path+": "+fmt.Sprintf(format, args...))
+	return nil
+}
+
+// enter marks the container c as being encoded, reporting a cycle if it
+// already is.
+func (e *snapEncoder) enter(c interface{}, path string) bool {
+	if e.visited[c] {
+		e.fail(path, "cyclic %s", c.(starlark.Value).Type())
+		return false
+	}
+	e.visited[c] = true
+	return true
+}
+
+func (e *snapEncoder) encode(v starlark.Value, path string) interface{} {
+	switch v := v.(type) {
+	case starlark.NoneType:
+		return nil
+	case starlark.Bool:
+		return bool(v)
+	case starlark.String:
+		return string(v)
+	case starlark.Int:
+		return map[string]interface{}{"int": v.String()}
+	case starlark.Float:
+		return map[string]interface{}{"float": strconv.FormatFloat(float64(v), 'g', -1, 64)}
+	case starlark.Bytes:
+		return map[string]interface{}{"bytes": base64.StdEncoding.EncodeToString([]byte(v))}
+	case startime.Time:
+		return map[string]interface{}{"time": time.Time(v).Format(time.RFC3339Nano)}
+	case startime.Duration:
+		return map[string]interface{}{"duration": time.Duration(v).String()}
+	case *starlark.List:
+		if !e.enter(v, path) {
+			return nil
+		}
+		defer delete(e.visited, v)
+		return e.encodeSeq(v, path)
+	case starlark.Tuple:
+		return map[string]interface{}{"tuple": e.encodeSeq(v, path)}
+	case *starlark.Set:
+		if !e.enter(v, path) {
+			return nil
+		}
+		defer delete(e.visited, v)
+		return map[string]interface{}{"set": e.encodeSeq(v, path)}
+	case *starlark.Dict:
+		if !e.enter(v, path) {
+			return nil
+		}
+		defer delete(e.visited, v)
+		items := make([]interface{}, 0, v.Len())
+		for _, kv := range v.Items() {
+			p := fmt.Sprintf("%s[%s]", path, kv[0].String())
+			items = append(items, []interface{}{e.encode(kv[0], p+" (key)"), e.encode(kv[1], p)})
+		}
+		return map[string]interface{}{"dict": items}
+	case *convert.GoStruct:
+		return e.encodeGo(v, v.Value(), path)
+	case *convert.GoInterface:
+		return e.encodeGo(v, v.Value(), path)
+	case *convert.GoMap:
+		return e.encodeGo(v, v.Value(), path)
+	case *convert.GoSlice:
+		return e.encodeGo(v, v.Value(), path)
+	}
+	return e.fail(path, "cannot serialize %s value", v.Type())
+}
+
+func (e *snapEncoder) encodeSeq(seq starlark.Iterable, path string) []interface{} {
+	out := []interface{}{}
+	it := seq.Iterate()
+	defer it.Done()
+	var x starlark.Value
+	for i := 0; it.Next(&x); i++ {
+		out = append(out, e.encode(x, fmt.Sprintf("%s[%d]", path, i)))
+	}
+	return out
+}
+
+// goKey identifies a Go value that may refer back to itself, so that a
+// cyclic Go value is reported rather than followed forever.
+type goKey struct {
+	t   reflect.Type
+	p   uintptr
+	len int
+}
+
+// enterGo marks the Go value rv as being encoded, like enter.
+func (e *snapEncoder) enterGo(v starlark.Value, rv reflect.Value, path string) bool {
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		k := goKey{t: rv.Type(), p: rv.Pointer()}
+		if rv.Kind() == reflect.Slice {
+			k.len = rv.Len()
+		}
+		if e.visited[k] {
+			e.fail(path, "cyclic %s", v.Type())
+			return false
+		}
+		e.visited[k] = true
+	}
+	return true
+}
+
+// leaveGo unmarks the Go value rv once it is encoded.
+func (e *snapEncoder) leaveGo(rv reflect.Value) {
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		k := goKey{t: rv.Type(), p: rv.Pointer()}
+		if rv.Kind() == reflect.Slice {
+			k.len = rv.Len()
+		}
+		delete(e.visited, k)
+	}
+}
+
+// encodeGo encodes a wrapped Go value as scripts see it: a struct as a dict
+// of its fields keyed by their Starlark names, a map as a dict, a slice or
+// array as a list, and the value of a GoInterface as the matching scalar.
+func (e *snapEncoder) encodeGo(v starlark.Value, rv reflect.Value, path string) interface{} {
+	if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice) && rv.IsNil() {
+		return nil
+	}
+	if !e.enterGo(v, rv, path) {
+		return nil
+	}
+	defer e.leaveGo(rv)
+	switch v := v.(type) {
+	case *convert.GoStruct:
+		st := rv.Type()
+		if st.Kind() == reflect.Ptr {
+			st = st.Elem()
+		}
+		pt := reflect.PtrTo(st)
+		items := []interface{}{}
+		for _, name := range v.AttrNames() {
+			if _, ok := pt.MethodByName(name); ok {
+				continue
+			}
+			x, err := v.Attr(name)
+			if err != nil {
+				e.fail(path, "%v", err)
+				continue
+			}
+			items = append(items, []interface{}{name, e.encode(x, path+"."+name)})
+		}
+		return map[string]interface{}{"dict": items}
+	case *convert.GoMap:
+		items := make([]interface{}, 0, v.Len())
+		for _, kv := range v.Items() {
+			p := fmt.Sprintf("%s[%s]", path, kv[0].String())
+			items = append(items, []interface{}{e.encode(kv[0], p+" (key)"), e.encode(kv[1], p)})
+		}
+		return map[string]interface{}{"dict": items}
+	case *convert.GoSlice:
+		return e.encodeSeq(v, path)
+	}
+	// a GoInterface holds a scalar or a pointer to one
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return rv.Bool()
+	case reflect.String:
+		return rv.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"int": strconv.FormatInt(rv.Int(), 10)}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"int": strconv.FormatUint(rv.Uint(), 10)}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"float": strconv.FormatFloat(rv.Float(), 'g', -1, 64)}
+	}
+	return e.fail(path, "cannot serialize %s value", v.Type())
+}
+
+// decodeSnapValue turns a JSON tree decoded with UseNumber back into a
+// Starlark value.
+func decodeSnapValue(v interface{}) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []interface{}:
+		elems, err := decodeSnapSeq(v)
+		if err != nil {
+			return nil, err
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		if len(v) != 1 {
+			return nil, fmt.Errorf("invalid tagged value with %d keys", len(v))
+		}
+		for tag, x := range v {
+			return decodeSnapTagged(tag, x)
+		}
+	}
+	return nil, fmt.Errorf("unexpected JSON value %v", v)
+}
+
+func decodeSnapSeq(v []interface{}) ([]starlark.Value, error) {
+	elems := make([]starlark.Value, len(v))
+	for i, x := range v {
+		e, err := decodeSnapValue(x)
+		if err != nil {
+			return nil, fmt.Errorf("[%d]: %v", i, err)
+		}
+		elems[i] = e
+	}
+	return elems, nil
+}
+
+func decodeSnapTagged(tag string, x interface{}) (starlark.Value, error) {
+	if tag == "tuple" || tag == "set" || tag == "dict" {
+		arr, ok := x.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: want a JSON array", tag)
+		}
+		switch tag {
+		case "tuple":
+			elems, err := decodeSnapSeq(arr)
+			if err != nil {
+				return nil, fmt.Errorf("tuple%v", err)
+			}
+			return starlark.Tuple(elems), nil
+		case "set":
+			elems, err := decodeSnapSeq(arr)
+			if err != nil {
+				return nil, fmt.Errorf("set%v", err)
+			}
+			set := starlark.NewSet(len(elems))
+			for _, e := range elems {
+				if err := set.Insert(e); err != nil {
+					return nil, fmt.Errorf("set: %v", err)
+				}
+			}
+			return set, nil
+		default:
+			dict := starlark.NewDict(len(arr))
+			for i, item := range arr {
+				kv, ok := item.([]interface{})
+				if !ok || len(kv) != 2 {
+					return nil, fmt.Errorf("dict[%d]: want a [key, value] pair", i)
+				}
+				pair, err := decodeSnapSeq(kv)
+				if err != nil {
+					return nil, fmt.Errorf("dict[%d]%v", i, err)
+				}
+				if err := dict.SetKey(pair[0], pair[1]); err != nil {
+					return nil, fmt.Errorf("dict[%d]: %v", i, err)
+				}
+			}
+			return dict, nil
+		}
+	}
+
+	s, ok := x.(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: want a JSON string", tag)
+	}
+	switch tag {
+	case "int":
+		i, ok := new(big.Int).SetString(s, 10)
+		if !ok {
+			return nil, fmt.Errorf("int: invalid value %q", s)
+		}
+		return starlark.MakeBigInt(i), nil
+	case "float":
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, fmt.Errorf("float: %v", err)
+		}
+		return starlark.Float(f), nil
+	case "bytes":
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, fmt.Errorf("bytes: %v", err)
+		}
+		return starlark.Bytes(b), nil
+	case "time":
+		t, err := time.Parse(time.RFC3339Nano, s)
+		if err != nil {
+			return nil, fmt.Errorf("time: %v", err)
+		}
+		return startime.Time(t), nil
+	case "duration":
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("duration: %v", err)
+		}
+		return startime.Duration(d), nil
+	}
+	return nil, fmt.Errorf("unknown type tag %q", tag)
+}
@@ -0,0 +1,191 @@
+package starlight
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1set/starlight/convert"
+	startime "go.starlark.net/lib/time"
+	"go.starlark.net/starlark"
+)
+
+type snapPoint struct {
+	X, Y  int
+	Label string  `starlark:"label" json:"name"`
+	Scale float64 `starlark:"scale"`
+}
+
+// Norm is a method, which snapshots leave out.
+func (p snapPoint) Norm() int { return p.X*p.X + p.Y*p.Y }
+
+type snapNode struct {
+	Next *snapNode
+}
+
+// execSnapScript runs src and returns its globals as Starlark values.
+func execSnapScript(src string, predeclared starlark.StringDict) (starlark.StringDict, error) {
+	return starlark.ExecFileOptions(dialectOptions, &starlark.Thread{}, "snap.star", src, predeclared)
+}
+
+// TestSnapshotRoundTrip verifies every supported type restores with its
+// type and value intact.
+func TestSnapshotRoundTrip(t *testing.T) {
+	predeclared, err := convert.MakeStringDict(map[string]interface{}{
+		"point": snapPoint{X: 1, Y: 2, Label: "p", Scale: 2},
+		"tags":  map[string][]int{"b": {2}, "a": {1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	predeclared["time"] = startime.Module
+	dict, err := execSnapScript(`
+none = None
+flag = True
+name = "starlight"
+small = 42
+big = 1 << 100
+neg = -7
+ratio = 0.25
+inf = float("inf")
+raw = b"\x00\xff"
+items = [1, "two", (3, 4.0)]
+pair = (1, (2,))
+table = {"a": [1, 2], (1, 2): {"nested": None}, 3: b"x"}
+uniq = set([1, "x", (2, 3)])
+when = time.time(year=2024, month=5, day=6, hour=7, minute=8, second=9, nanosecond=10)
+span = time.parse_duration("1h2m3.5s")
+empty = []
+p = point
+t = tags
+`, predeclared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// NaN never equals itself, so it is checked separately
+	dict["nan"] = starlark.Float(math.NaN())
+
+	data, err := SnapshotGlobals(dict)
+	if err != nil {
+		t.Fatal(err)
+	}
+	back, err := RestoreGlobals(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(back) != len(dict) {
+		t.Fatalf("restored %d globals, want %d", len(back), len(dict))
+	}
+	for name, want := range dict {
+		got := back[name]
+		switch name {
+		case "nan":
+			if f, ok := got.(starlark.Float); !ok || !math.IsNaN(float64(f)) {
+				t.Errorf("nan = %v", got)
+			}
+			continue
+		case "p":
+			if got.String() != `{"X": 1, "Y": 2, "label": "p", "scale": 2.0}` {
+				t.Errorf("point = %v", got)
+			}
+			continue
+		case "t":
+			if got.String() != `{"a": [1], "b": [2]}` {
+				t.Errorf("tags = %v", got)
+			}
+			continue
+		}
+		if got.Type() != want.Type() {
+			t.Errorf("%s: type %s, want %s", name, got.Type(), want.Type())
+			continue
+		}
+		if eq, err := starlark.Equal(got, want); err != nil || !eq {
+			t.Errorf("%s = %v, want %v", name, got, want)
+		}
+	}
+	if tup := back["items"].(*starlark.List).Index(2); tup.Type() != "tuple" {
+		t.Errorf("nested tuple restored as %s", tup.Type())
+	}
+	if back["items"].(*starlark.List).Index(2).(starlark.Tuple)[1].Type() != "float" {
+		t.Error("float 4.0 did not stay a float")
+	}
+	if back["when"].(startime.Time) != startime.Time(time.Date(2024, 5, 6, 7, 8, 9, 10, time.UTC)) {
+		t.Errorf("when = %v", back["when"])
+	}
+
+	// the restored globals feed a later run: Starlark values pass through
+	// the conversion of run globals unchanged
+	globals := make(map[string]interface{}, len(back))
+	for k, v := range back {
+		globals[k] = v
+	}
+	out, err := Eval([]byte("total = big + small\n"), globals, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(out["total"]); got != "1267650600228229401496703205418" {
+		t.Errorf("total = %v", got)
+	}
+}
+
+// TestSnapshotErrors verifies every value that cannot be serialized is
+// reported by its path, and that malformed snapshots are rejected.
+func TestSnapshotErrors(t *testing.T) {
+	dict, err := execSnapScript(`
+def f():
+    pass
+handlers = {"x": [f, 1]}
+fn = f
+ok = 1
+`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cyclic := starlark.NewList(nil)
+	cyclic.Append(cyclic)
+	dict["cyclic"] = cyclic
+	dict["builtin"] = convert.MakeStarFn("b", func() {})
+	if dict["ch"], err = convert.ToValue(&struct{ C chan int }{make(chan int)}); err != nil {
+		t.Fatal(err)
+	}
+	node := &snapNode{}
+	node.Next = node
+	if dict["node"], err = convert.ToValue(node); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = SnapshotGlobals(dict)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		`handlers["x"][0]: cannot serialize function value`,
+		"fn: cannot serialize function value",
+		"cyclic[0]: cyclic list",
+		"builtin: cannot serialize builtin_function_or_method value",
+		"ch.C: cannot serialize starlight_chan<chan int> value",
+		"node.Next: cyclic starlight_struct<*starlight.snapNode>",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if strings.Contains(err.Error(), "ok:") {
+		t.Errorf("error mentions a valid global: %v", err)
+	}
+
+	for _, bad := range []string{
+		`not json`,
+		`{"version": 2, "globals": {}}`,
+		`{"version": 1, "globals": {"x": {"int": "1.5"}}}`,
+		`{"version": 1, "globals": {"x": {"what": "1"}}}`,
+		`{"version": 1, "globals": {"x": {"dict": [[[1], 2]]}}}`,
+		`{"version": 1, "globals": {"x": 12}}`,
+	} {
+		if _, err := RestoreGlobals([]byte(bad)); err == nil {
+			t.Errorf("RestoreGlobals(%s) succeeded", bad)
+		}
+	}
+}
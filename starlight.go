@@ -2,14 +2,16 @@
 package starlight
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"path"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
 
 	"github.com/1set/starlight/convert"
+	"go.starlark.net/resolve"
 	"go.starlark.net/starlark"
 	"go.starlark.net/syntax"
 )
@@ -28,24 +30,31 @@ type LoadFunc func(thread *starlark.Thread, module string) (starlark.StringDict,
 
 // Eval evaluates the starlark source with the given global variables. The type
 // of the argument for the src parameter must be string (filename), []byte, or io.Reader.
-func Eval(src interface{}, globals map[string]interface{}, load LoadFunc) (map[string]interface{}, error) {
-	dict, err := convert.MakeStringDict(globals)
+// The options configure the thread the source runs on and can shape the
+// returned globals (see RunOption).
+func Eval(src interface{}, globals map[string]interface{}, load LoadFunc, opts ...RunOption) (_ map[string]interface{}, err error) {
+	rc := newRunConfig(opts)
+	dict, err := rc.makeDict(globals)
 	if err != nil {
 		return nil, err
 	}
-	thread := &starlark.Thread{
-		Load: load,
+	rs, err := rc.start()
+	if err != nil {
+		return nil, err
 	}
+	defer rs.finish(&err)
+	thread := rs.newThread(load)
+	var ret starlark.StringDict
 	filename, ok := src.(string)
 	if ok {
-		dict, err = starlark.ExecFileOptions(dialectOptions, thread, filename, nil, dict)
+		ret, err = starlark.ExecFileOptions(dialectOptions, thread, filename, nil, bindThread(dict, thread))
 	} else {
-		dict, err = execNonFileSource(thread, src, dict)
+		ret, err = execNonFileSource(thread, src, bindThread(dict, thread))
 	}
 	if err != nil {
 		return nil, err
 	}
-	return convert.FromStringDict(dict), nil
+	return convert.FromStringDict(rc.filter.apply(ret, dict)), nil
 }
 
 // execNonFileSource runs a non-filename source ([]byte or io.Reader). It
@@ -62,23 +71,15 @@ func execNonFileSource(thread *starlark.Thread, src interface{}, dict starlark.S
 
 // Cache is a cache of scripts to avoid re-reading files and re-parsing them.
 type Cache struct {
-	_       convert.DoNotCompare
-	dirs    []string
-	cache   *cache
-	mu      sync.Mutex
-	scripts map[string]*starlark.Program
-}
-
-func run(p *starlark.Program, globals map[string]interface{}, load LoadFunc) (map[string]interface{}, error) {
-	g, err := convert.MakeStringDict(globals)
-	if err != nil {
-		return nil, err
-	}
-	ret, err := p.Init(&starlark.Thread{Load: load}, g)
-	if err != nil {
-		return nil, err
-	}
-	return convert.FromStringDict(ret), nil
+	_          convert.DoNotCompare
+	loader     SourceLoader
+	cache      *cache
+	mu         sync.Mutex
+	scripts    map[string]*starlark.Program
+	versions   map[string]string // non-empty script versions, by scripts key
+	runGlobals bool              // see SetModuleRunGlobals
+	runOpts    []RunOption       // see SetRunOptions
+	group      *CacheGroup       // set for the tenants of a CacheGroup
 }
 
 // New returns a Starlight Cache that looks in the given directories for plugin
@@ -89,7 +90,7 @@ func New(dirs ...string) *Cache {
 	if len(dirs) == 0 {
 		panic(fmt.Errorf("no directories given"))
 	}
-	return newCache(dirs, nil)
+	return newCache(DirLoader(dirs), nil)
 }
 
 // WithGlobals returns a new Starlight cache that passes the listed global
@@ -104,13 +105,28 @@ func WithGlobals(globals map[string]interface{}, dirs ...string) (*Cache, error)
 	if err != nil {
 		return nil, err
 	}
-	return newCache(dirs, g), nil
+	return newCache(DirLoader(dirs), g), nil
+}
+
+// WithLoader returns a new Starlight cache that reads scripts, for Run as
+// well as for the script function load(), from loader instead of from
+// directories. The globals are passed to loaded modules as by WithGlobals.
+func WithLoader(loader SourceLoader, globals map[string]interface{}) (*Cache, error) {
+	if loader == nil {
+		return nil, fmt.Errorf("no loader given")
+	}
+	g, err := convert.MakeStringDict(globals)
+	if err != nil {
+		return nil, err
+	}
+	return newCache(loader, g), nil
 }
 
-func newCache(dirs []string, globals starlark.StringDict) *Cache {
+func newCache(loader SourceLoader, globals starlark.StringDict) *Cache {
 	c := &Cache{
-		dirs:    dirs,
-		scripts: map[string]*starlark.Program{},
+		loader:   loader,
+		scripts:  map[string]*starlark.Program{},
+		versions: map[string]string{},
 	}
 	c.cache = &cache{
 		cache:    make(map[string]*entry),
@@ -123,31 +139,230 @@ func newCache(dirs []string, globals starlark.StringDict) *Cache {
 // Run looks for a file with the given filename, and runs it with the given globals
 // passed to the script's global namespace. The return value is all convertible
 // global variables from the script, which may include the passed-in globals.
-func (c *Cache) Run(filename string, globals map[string]interface{}) (map[string]interface{}, error) {
-	dict, err := convert.MakeStringDict(globals)
+// The options configure the thread the script runs on and can shape the
+// returned globals (see RunOption).
+func (c *Cache) Run(filename string, globals map[string]interface{}, opts ...RunOption) (map[string]interface{}, error) {
+	rc := c.runConfig(opts)
+	dict, err := rc.makeDict(globals)
 	if err != nil {
 		return nil, err
 	}
-	key := scriptCacheKey(filename, dict)
+	ret, err := c.exec(filename, dict, rc)
+	if err != nil {
+		return nil, err
+	}
+	return convert.FromStringDict(rc.filter.apply(ret, dict)), nil
+}
+
+// SetRunOptions sets options applied to every run of the cache — Run,
+// RunBatch and the plugins of a PluginHost — before the options of the run
+// itself, e.g. to trace all calls of one tenant's cache with WithTracer.
+// Calling it again replaces the previous options.
+func (c *Cache) SetRunOptions(opts ...RunOption) {
 	c.mu.Lock()
-	if p, ok := c.scripts[key]; ok {
-		c.mu.Unlock()
-		return run(p, globals, c.Load)
+	c.runOpts = append([]RunOption(nil), opts...)
+	c.mu.Unlock()
+}
+
+// runConfig builds the configuration of a run from the cache options
+// followed by opts.
+func (c *Cache) runConfig(opts []RunOption) *runConfig {
+	c.mu.Lock()
+	all := append(append([]RunOption(nil), c.runOpts...), opts...)
+	c.mu.Unlock()
+	return newRunConfig(all)
+}
+
+// exec runs the script filename with the predeclared values in dict under
+// the run configuration rc, and returns the globals the script defined.
+func (c *Cache) exec(filename string, dict starlark.StringDict, rc *runConfig) (starlark.StringDict, error) {
+	p, err := c.program(filename, dict)
+	if err != nil {
+		return nil, err
 	}
+	return c.execProgram(p, dict, rc)
+}
+
+// execProgram runs p, the compiled program of a script, as exec does.
+func (c *Cache) execProgram(p *starlark.Program, dict starlark.StringDict, rc *runConfig) (_ starlark.StringDict, err error) {
+	rs, err := rc.start()
+	if err != nil {
+		return nil, err
+	}
+	defer rs.finish(&err)
+	return c.execProgramIn(rs, p, dict)
+}
+
+// execIn runs the script filename as part of the started run rs.
+func (c *Cache) execIn(rs *runState, filename string, dict starlark.StringDict) (starlark.StringDict, error) {
+	p, err := c.program(filename, dict)
+	if err != nil {
+		return nil, err
+	}
+	return c.execProgramIn(rs, p, dict)
+}
+
+// execProgramIn runs p, the compiled program of a script, as part of the
+// started run rs.
+func (c *Cache) execProgramIn(rs *runState, p *starlark.Program, dict starlark.StringDict) (starlark.StringDict, error) {
+	c.mu.Lock()
+	perRun := c.runGlobals
 	c.mu.Unlock()
+	load := LoadFunc(c.Load)
+	if perRun {
+		loader := c.runLoader(rs, dict)
+		load = func(_ *starlark.Thread, module string) (starlark.StringDict, error) {
+			return loader.Load(module)
+		}
+	}
+	thread := rs.newThread(load)
+	ret, err := p.Init(thread, bindThread(dict, thread))
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// program returns the compiled program for filename under the predeclared
+// name set of dict, compiling and caching it on first use. Top-level
+// scripts and (in per-run globals mode) load()ed modules share this cache.
+// A script the loader gave a version is compiled again once the loader
+// reports a different one (see Versioner).
+func (c *Cache) program(filename string, dict starlark.StringDict) (*starlark.Program, error) {
+	key := scriptCacheKey(filename, dict)
+	c.mu.Lock()
+	p, ok := c.scripts[key]
+	version, versioned := c.versions[key]
+	c.mu.Unlock()
+	if ok && (!versioned || c.current(filename, version)) {
+		if c.group != nil {
+			c.group.touch(p)
+		}
+		return p, nil
+	}
 
-	b, err := c.readFile(filename)
+	b, version, err := c.loader.Load(filename)
 	if err != nil {
 		return nil, err
 	}
-	_, p, err := starlark.SourceProgramOptions(dialectOptions, filename, b, dict.Has)
+	if c.group != nil {
+		return c.group.program(c, key, filename, b, version, dict)
+	}
+	_, p, err = starlark.SourceProgramOptions(dialectOptions, filename, b, dict.Has)
 	if err != nil {
 		return nil, err
 	}
 	c.mu.Lock()
+	c.store(key, p, version)
+	c.mu.Unlock()
+	return p, nil
+}
+
+// store caches p, of the given script version, under key. The caller
+// holds c.mu.
+func (c *Cache) store(key string, p *starlark.Program, version string) {
 	c.scripts[key] = p
+	if version != "" {
+		c.versions[key] = version
+	} else {
+		delete(c.versions, key)
+	}
+}
+
+// current reports whether version is still the version of the script
+// filename, as far as the loader can tell without loading it.
+func (c *Cache) current(filename, version string) bool {
+	v, ok := c.loader.(Versioner)
+	if !ok {
+		return true
+	}
+	cur, err := v.Version(filename)
+	return err == nil && cur == version
+}
+
+// Preload compiles every script matching pattern (see matchScript; empty
+// means "*.star") the cache's loader lists (see Lister) — for directories,
+// every file in them and their subdirectories — so that broken scripts are
+// found at deploy time rather than at their first Run. Each script is
+// compiled as if run with globals named by predeclared, and the compiled
+// programs are cached, so a later Run with globals of those same names
+// starts without compiling.
+//
+// Preload compiles every matching file even if some fail, and returns an
+// error listing each syntax or resolve error of every broken file with its
+// position.
+func (c *Cache) Preload(pattern string, predeclared []string) error {
+	if pattern == "" {
+		pattern = "*.star"
+	}
+	files, err := c.listScripts(pattern)
+	if err != nil {
+		return err
+	}
+	dict := make(starlark.StringDict, len(predeclared))
+	for _, name := range predeclared {
+		dict[name] = starlark.None
+	}
+	var problems []string
+	for _, file := range files {
+		if _, err := c.program(file, dict); err != nil {
+			problems = append(problems, compileProblems(file, err)...)
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("starlight: cannot compile scripts:\n\t%s", strings.Join(problems, "\n\t"))
+	}
+	return nil
+}
+
+// compileProblems lists the errors in err, returned by compiling file: a
+// resolve error holds one entry per unresolved name, while syntax errors
+// stop at the first one. Errors without a position are prefixed with file.
+func compileProblems(file string, err error) []string {
+	var list resolve.ErrorList
+	if errors.As(err, &list) {
+		out := make([]string, len(list))
+		for i, e := range list {
+			out[i] = e.Error()
+		}
+		return out
+	}
+	var serr syntax.Error
+	if errors.As(err, &serr) {
+		return []string{serr.Error()}
+	}
+	return []string{fmt.Sprintf("%s: %v", file, err)}
+}
+
+// SetModuleRunGlobals controls whether modules reached through load() see
+// the globals passed to Run. By default they only see the fixed globals set
+// by WithGlobals, and each module is executed once and shared by every run.
+//
+// When enabled, a module loaded during a Run is executed with the cache
+// globals merged with that run's globals (run values win on a name clash).
+// Modules are then executed once per run instead of once per cache, so
+// per-request values never leak from one run into another; the compiled
+// programs are still cached, keyed by the merged predeclared name set as
+// for top-level scripts (see scriptCacheKey).
+func (c *Cache) SetModuleRunGlobals(enabled bool) {
+	c.mu.Lock()
+	c.runGlobals = enabled
 	c.mu.Unlock()
-	return run(p, globals, c.Load)
+}
+
+// runLoader returns the module loader for a single run in per-run globals
+// mode: a fresh module cache (so loads are deduplicated and cycle-checked
+// within the run, but never shared across runs) whose modules execute with
+// the merge of the cache globals and the run globals, on threads set up
+// like the run's own.
+func (c *Cache) runLoader(rs *runState, dict starlark.StringDict) *cache {
+	return &cache{
+		cache:    make(map[string]*entry),
+		globals:  mergeDicts(c.cache.globals, dict),
+		readFile: c.readFile,
+		program:  c.program,
+		setup:    rs.setup,
+	}
 }
 
 // scriptCacheKey composes the key under which a compiled program is cached.
@@ -168,36 +383,53 @@ func scriptCacheKey(filename string, dict starlark.StringDict) string {
 	return filename + "\x00" + strings.Join(names, "\x00")
 }
 
-// Load loads a module using the cache's configured directories.
+// Load loads a module using the cache's loader.
 func (c *Cache) Load(_ *starlark.Thread, module string) (starlark.StringDict, error) {
 	return c.cache.Load(module)
 }
 
 func (c *Cache) readFile(filename string) ([]byte, error) {
-	var err error
-	var b []byte
-	for _, d := range c.dirs {
-		full := filepath.Join(d, filename)
-		// Containment: filepath.Join cleans embedded ".." segments, so a
-		// script-controlled name like "../secret.star" (Run and the load()
-		// path both reach here) can resolve above d and read a sibling or
-		// parent file. Skip any candidate that escapes d; a name that stays
-		// within a *different* configured dir is still served from that one,
-		// and only a name that escapes every dir falls through to not-found.
-		// This is defense in depth — confinement is not a guarantee of New()
-		// (real sandboxing belongs to the host layer), but the search scope
-		// should not silently reach outside the directories it was given.
-		if !withinDir(d, full) {
-			continue
+	b, _, err := c.loader.Load(filename)
+	return b, err
+}
+
+// listScripts returns the names of the scripts matching pattern (see
+// matchScript) the loader lists, sorted; it requires a loader implementing
+// Lister. For a DirLoader those are the files of every configured directory
+// and its subdirectories.
+func (c *Cache) listScripts(pattern string) ([]string, error) {
+	l, ok := c.loader.(Lister)
+	if !ok {
+		return nil, fmt.Errorf("starlight: the script loader %T cannot list scripts", c.loader)
+	}
+	all, err := l.List()
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range all {
+		ok, err := matchScript(pattern, name)
+		if err != nil {
+			return nil, err
 		}
-		b, err = ioutil.ReadFile(full)
-		if err == nil {
-			return b, nil
+		if ok && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
 		}
 	}
-	// guaranteed to have at least one directory, so there should be at least
-	// not found error here.
-	return nil, fmt.Errorf("cannot find file %q in any of the configured directories %q", filename, c.dirs)
+	sort.Strings(names)
+	return names, nil
+}
+
+// matchScript reports whether the slash-separated relative name matches
+// pattern (path.Match syntax). A pattern without a slash is matched against
+// the base name only, so "*.star" selects scripts in subdirectories too.
+func matchScript(pattern, name string) (bool, error) {
+	if !strings.Contains(pattern, "/") {
+		name = path.Base(name)
+	}
+	return path.Match(pattern, name)
 }
 
 // withinDir reports whether the cleaned path full is dir itself or lives
@@ -215,8 +447,12 @@ func withinDir(dir, full string) bool {
 func (c *Cache) Reset() {
 	c.mu.Lock()
 	c.scripts = map[string]*starlark.Program{}
+	c.versions = map[string]string{}
 	c.cache.reset()
 	c.mu.Unlock()
+	if c.group != nil {
+		c.group.release(c)
+	}
 }
 
 // Forget clears the cached script for the given filename.
@@ -231,7 +467,11 @@ func (c *Cache) Forget(filename string) {
 	for k := range c.scripts {
 		if strings.HasPrefix(k, prefix) {
 			delete(c.scripts, k)
+			delete(c.versions, k)
 		}
 	}
 	c.mu.Unlock()
+	if c.group != nil {
+		c.group.release(c)
+	}
 }
@@ -17,6 +17,7 @@ import (
 //      the conversion behaviors through the real interpreter
 //   4. Cache-key isolation by predeclared name set; readFile path
 //      containment
+//   5. Per-run globals for load()ed modules (SetModuleRunGlobals)
 
 // Importing starlight (and, transitively, convert) must not mutate any
 // process-global state: the dialect is passed explicitly to every
@@ -147,7 +148,7 @@ func TestWithGlobalsNoDirs(t *testing.T) {
 // TestWithGlobalsConvertError verifies an unconvertible global surfaces from
 // the constructor rather than panicking.
 func TestWithGlobalsConvertError(t *testing.T) {
-	if _, err := WithGlobals(map[string]interface{}{"bad": make(chan int)}, t.TempDir()); err == nil {
+	if _, err := WithGlobals(map[string]interface{}{"bad": uintptr(1)}, t.TempDir()); err == nil {
 		t.Fatal("expected conversion error for an unsupported global type")
 	}
 }
@@ -348,3 +349,87 @@ func TestCacheMultiDirSiblingAccess(t *testing.T) {
 		t.Fatalf("multi-dir sibling access v = %v, want 7", res["v"])
 	}
 }
+
+// ---- Section 5: per-run globals for load()ed modules ----
+
+// TestModuleRunGlobals verifies the opt-in mode that executes load()ed
+// modules with the cache globals merged with the globals of the current
+// run, and that module results are never shared between runs.
+func TestModuleRunGlobals(t *testing.T) {
+	dir := t.TempDir()
+	module := `who = prefix + user`
+	main := `
+load("mod.star", "who")
+out = who
+`
+	if err := os.WriteFile(filepath.Join(dir, "mod.star"), []byte(module), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.star"), []byte(main), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := WithGlobals(map[string]interface{}{"prefix": "user:", "user": "nobody"}, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// default mode: the module sees only the cache globals
+	res, err := c.Run("main.star", map[string]interface{}{"user": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["out"] != "user:nobody" {
+		t.Fatalf("default mode out = %v, want user:nobody", res["out"])
+	}
+
+	// per-run mode: run globals override cache globals, per run
+	c.SetModuleRunGlobals(true)
+	for _, user := range []string{"alice", "bob", "alice"} {
+		res, err := c.Run("main.star", map[string]interface{}{"user": user})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "user:" + user; res["out"] != want {
+			t.Fatalf("per-run mode out = %v, want %v", res["out"], want)
+		}
+	}
+
+	// a run-only name is visible to the module too
+	if err := os.WriteFile(filepath.Join(dir, "tenant.star"), []byte("load(\"tmod.star\", \"t\")\nout = t\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tmod.star"), []byte("t = tenant * 2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	res, err = c.Run("tenant.star", map[string]interface{}{"tenant": 21})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res["out"] != int64(42) {
+		t.Fatalf("run-only global out = %v, want 42", res["out"])
+	}
+
+	// switching the mode off restores the shared module cache
+	c.SetModuleRunGlobals(false)
+	if _, err := c.Run("tenant.star", map[string]interface{}{"tenant": 21}); err == nil || !strings.Contains(err.Error(), "undefined: tenant") {
+		t.Fatalf("expected 'undefined: tenant' once the mode is off, got %v", err)
+	}
+}
+
+// TestModuleRunGlobalsCycle verifies the per-run module loader keeps the
+// load-cycle detection of the shared one.
+func TestModuleRunGlobalsCycle(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.star"), []byte("load(\"b.star\", \"b\")\na = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "b.star"), []byte("load(\"a.star\", \"a\")\nb = 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c := New(dir)
+	c.SetModuleRunGlobals(true)
+	_, err := c.Run("a.star", map[string]interface{}{"x": 1})
+	if err == nil || !strings.Contains(err.Error(), "cycle in load graph") {
+		t.Fatalf("expected a load cycle error, got %v", err)
+	}
+}
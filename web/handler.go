@@ -0,0 +1,316 @@
+// Package web serves HTTP requests with Starlark scripts run by a
+// starlight.Cache, e.g. for customer-written webhooks.
+//
+// Each request runs the script routed to its path with a read-only request
+// global:
+//
+//	request.method   # "POST"
+//	request.path     # "/hooks/order"
+//	request.headers  # {"Content-Type": "application/json", ...}
+//	request.query    # {"id": "42", ...}
+//	request.body     # the body, as bytes
+//	request.json     # the body parsed as JSON, or None
+//
+// and answers with the script's response global, e.g.
+//
+//	response = {"status": 201, "headers": {"X-Id": "7"}, "body": {"ok": True}}
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/1set/starlight"
+	"github.com/1set/starlight/convert"
+	starjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// RequestGlobal is the name of the global holding the request, and
+// ResponseGlobal the name of the global a script answers with.
+const (
+	RequestGlobal  = "request"
+	ResponseGlobal = "response"
+)
+
+// DefaultMaxBodyBytes is the request body limit of a Handler whose
+// MaxBodyBytes is zero.
+const DefaultMaxBodyBytes = 1 << 20
+
+// Handler is an http.Handler running a script per request. Its exported
+// fields must not be modified while it serves requests.
+type Handler struct {
+	_      convert.DoNotCompare
+	cache  *starlight.Cache
+	routes map[string]string
+
+	// Timeout bounds each run; zero means the request's context only.
+	Timeout time.Duration
+	// MaxSteps bounds the Starlark computation steps of each run (see
+	// starlight.WithMaxSteps); zero means unlimited.
+	MaxSteps uint64
+	// MaxBodyBytes bounds the request body; zero means DefaultMaxBodyBytes.
+	MaxBodyBytes int64
+	// Globals are passed to every script along with the request.
+	Globals map[string]interface{}
+	// Options are applied to every run, before the handler's own.
+	Options []starlight.RunOption
+	// ErrorLog, when set, is told about every request that failed with a
+	// 5xx status. The client only sees the status text, so script errors
+	// are not leaked to it.
+	ErrorLog func(r *http.Request, err error)
+}
+
+// NewHandler returns a Handler running, for each request, the script of c
+// that routes maps the request's URL path to. Requests to other paths get
+// a 404.
+func NewHandler(c *starlight.Cache, routes map[string]string) *Handler {
+	rt := make(map[string]string, len(routes))
+	for path, script := range routes {
+		rt[path] = script
+	}
+	return &Handler{cache: c, routes: rt}
+}
+
+// ServeHTTP implements http.Handler. A script that fails answers with a
+// 500, or a 504 if it ran out of time; a body over the limit gets a 413.
+// A script that defines no response global answers with a 204.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	script, ok := h.routes[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	req, err := h.request(w, r)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			h.fail(w, r, http.StatusRequestEntityTooLarge, nil)
+		} else {
+			h.fail(w, r, http.StatusBadRequest, nil)
+		}
+		return
+	}
+
+	ctx := r.Context()
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	globals := make(map[string]interface{}, len(h.Globals)+1)
+	for k, v := range h.Globals {
+		globals[k] = v
+	}
+	globals[RequestGlobal] = req
+	opts := append(append([]starlight.RunOption(nil), h.Options...),
+		starlight.WithContext(ctx),
+		starlight.IncludeOnly(ResponseGlobal))
+	if h.MaxSteps > 0 {
+		opts = append(opts, starlight.WithMaxSteps(h.MaxSteps))
+	}
+	out, err := h.cache.Run(script, globals, opts...)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		h.fail(w, r, status, fmt.Errorf("%s: %w", script, err))
+		return
+	}
+	resp, ok := out[ResponseGlobal]
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := writeResponse(w, resp); err != nil {
+		h.fail(w, r, http.StatusInternalServerError, fmt.Errorf("%s: %w", script, err))
+	}
+}
+
+// fail answers with status and logs err, if any.
+func (h *Handler) fail(w http.ResponseWriter, r *http.Request, status int, err error) {
+	if err != nil && h.ErrorLog != nil {
+		h.ErrorLog(r, err)
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// request reads r into the frozen request global.
+func (h *Handler) request(w http.ResponseWriter, r *http.Request) (starlark.Value, error) {
+	limit := h.MaxBodyBytes
+	if limit <= 0 {
+		limit = DefaultMaxBodyBytes
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		return nil, err
+	}
+
+	headers := starlark.NewDict(len(r.Header))
+	for name, values := range r.Header {
+		if err := headers.SetKey(starlark.String(name), starlark.String(strings.Join(values, ", "))); err != nil {
+			return nil, err
+		}
+	}
+	query := r.URL.Query()
+	params := starlark.NewDict(len(query))
+	for name, values := range query {
+		if err := params.SetKey(starlark.String(name), starlark.String(values[0])); err != nil {
+			return nil, err
+		}
+	}
+	req := starlarkstruct.FromStringDict(starlark.String(RequestGlobal), starlark.StringDict{
+		"method":  starlark.String(r.Method),
+		"path":    starlark.String(r.URL.Path),
+		"headers": headers,
+		"query":   params,
+		"body":    starlark.Bytes(body),
+		"json":    parseJSON(body),
+	})
+	req.Freeze()
+	return req, nil
+}
+
+// parseJSON returns body decoded by the Starlark json module, or None if it
+// is not JSON.
+func parseJSON(body []byte) starlark.Value {
+	if len(body) == 0 {
+		return starlark.None
+	}
+	decode := starjson.Module.Members["decode"]
+	thread := &starlark.Thread{Name: "json"}
+	v, err := starlark.Call(thread, decode, starlark.Tuple{starlark.String(body)}, nil)
+	if err != nil {
+		return starlark.None
+	}
+	return v
+}
+
+// writeResponse writes the response global resp, converted by
+// convert.FromValue: a dict or struct with the optional fields status,
+// headers and body, or a bare body. A str or bytes body is written as is;
+// any other body is encoded as JSON.
+func writeResponse(w http.ResponseWriter, resp interface{}) error {
+	status := http.StatusOK
+	var (
+		headers interface{}
+		body    interface{}
+	)
+	switch r := resp.(type) {
+	case map[interface{}]interface{}:
+		for k, v := range r {
+			switch k {
+			case "status":
+				n, ok := v.(int64)
+				if !ok {
+					return fmt.Errorf("response status must be an int, not %T", v)
+				}
+				status = int(n)
+			case "headers":
+				headers = v
+			case "body":
+				body = v
+			default:
+				return fmt.Errorf("response has unknown field %v", k)
+			}
+		}
+	case *starlarkstruct.Struct:
+		fields := make(map[interface{}]interface{})
+		for _, name := range r.AttrNames() {
+			v, err := r.Attr(name)
+			if err != nil {
+				return err
+			}
+			fields[name] = convert.FromValue(v)
+		}
+		return writeResponse(w, fields)
+	default:
+		body = resp
+	}
+	if status < 100 || status > 999 {
+		return fmt.Errorf("response status %d out of range", status)
+	}
+
+	var b []byte
+	switch v := body.(type) {
+	case nil:
+	case string:
+		b = []byte(v)
+	case []byte:
+		b = v
+	default:
+		var err error
+		if b, err = json.Marshal(jsonable(v)); err != nil {
+			return fmt.Errorf("cannot encode response body: %w", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+	}
+	if err := setHeaders(w.Header(), headers); err != nil {
+		return err
+	}
+	w.WriteHeader(status)
+	_, err := w.Write(b)
+	return err
+}
+
+// setHeaders adds the response headers hs, a dict from names to a str or a
+// list of strs.
+func setHeaders(h http.Header, hs interface{}) error {
+	if hs == nil {
+		return nil
+	}
+	m, ok := hs.(map[interface{}]interface{})
+	if !ok {
+		return fmt.Errorf("response headers must be a dict, not %T", hs)
+	}
+	for k, v := range m {
+		name, ok := k.(string)
+		if !ok {
+			return fmt.Errorf("response header name must be a str, not %T", k)
+		}
+		switch v := v.(type) {
+		case string:
+			h.Set(name, v)
+		case []interface{}:
+			h.Del(name)
+			for _, s := range v {
+				str, ok := s.(string)
+				if !ok {
+					return fmt.Errorf("response header %s must hold strs, not %T", name, s)
+				}
+				h.Add(name, str)
+			}
+		default:
+			return fmt.Errorf("response header %s must be a str or a list, not %T", name, v)
+		}
+	}
+	return nil
+}
+
+// jsonable turns the dicts converted by convert.FromValue, which have
+// interface{} keys, into maps encoding/json accepts.
+func jsonable(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonable(e)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(v))
+		for i, e := range v {
+			l[i] = jsonable(e)
+		}
+		return l
+	}
+	return v
+}
@@ -0,0 +1,146 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/1set/starlight"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+func newTestHandler(t *testing.T, scripts map[string]string) *Handler {
+	t.Helper()
+	dir := t.TempDir()
+	routes := make(map[string]string)
+	for name, src := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		routes["/"+strings.TrimSuffix(name, ".star")] = name
+	}
+	return NewHandler(starlight.New(dir), routes)
+}
+
+func serve(h http.Handler, method, target, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// TestHandler verifies a script sees the request and its response global
+// becomes the HTTP response.
+func TestHandler(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"echo.star": `
+response = {
+    "status": 201,
+    "headers": {"X-Method": request.method, "X-Tag": ["a", "b"]},
+    "body": {
+        "path": request.path,
+        "id": request.query["id"],
+        "agent": request.headers["User-Agent"],
+        "order": request.json["order"],
+        "size": len(request.body),
+    },
+}
+`,
+		"text.star":   `response = "hello " + greeting`,
+		"struct.star": `response = struct(status = 202, body = b"raw")`,
+		"quiet.star":  `seen = request.method`,
+	})
+	h.Globals = map[string]interface{}{"greeting": "world", "struct": starlark.NewBuiltin("struct", starlarkstruct.Make)}
+
+	rec := serve(h, "POST", "/echo?id=42", `{"order": 7}`, map[string]string{"User-Agent": "test"})
+	if rec.Code != 201 {
+		t.Fatalf("status = %d, body %q", rec.Code, rec.Body)
+	}
+	if got := rec.Header().Get("X-Method"); got != "POST" {
+		t.Errorf("X-Method = %q", got)
+	}
+	if got := rec.Header().Values("X-Tag"); len(got) != 2 {
+		t.Errorf("X-Tag = %q", got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	want := `{"agent":"test","id":"42","order":7,"path":"/echo","size":12}`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %s, want %s", got, want)
+	}
+
+	if rec := serve(h, "GET", "/text", "", nil); rec.Code != 200 || rec.Body.String() != "hello world" {
+		t.Errorf("text: %d %q", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", "/struct", "", nil); rec.Code != 202 || rec.Body.String() != "raw" {
+		t.Errorf("struct: %d %q", rec.Code, rec.Body)
+	}
+	if rec := serve(h, "GET", "/quiet", "", nil); rec.Code != 204 {
+		t.Errorf("quiet: %d", rec.Code)
+	}
+	if rec := serve(h, "GET", "/missing", "", nil); rec.Code != 404 {
+		t.Errorf("missing: %d", rec.Code)
+	}
+}
+
+// TestHandlerFailures verifies the request is read-only, failures do not
+// leak script errors to the client, and the body, step and time limits
+// apply.
+func TestHandlerFailures(t *testing.T) {
+	h := newTestHandler(t, map[string]string{
+		"mutate.star": `request.query["id"] = "0"`,
+		"bad.star":    `response = {"status": "ok"}`,
+		"spin.star": `
+def spin():
+    n = 0
+    for i in range(1 << 40):
+        n += 1
+    return n
+response = spin()
+`,
+	})
+	var logged []string
+	h.ErrorLog = func(r *http.Request, err error) {
+		logged = append(logged, err.Error())
+	}
+	h.MaxBodyBytes = 8
+
+	rec := serve(h, "GET", "/mutate?id=1", "", nil)
+	if rec.Code != 500 || strings.Contains(rec.Body.String(), "frozen") {
+		t.Errorf("mutate: %d %q", rec.Code, rec.Body)
+	}
+	if len(logged) != 1 || !strings.Contains(logged[0], "frozen") {
+		t.Errorf("logged %q", logged)
+	}
+	if rec := serve(h, "GET", "/bad", "", nil); rec.Code != 500 {
+		t.Errorf("bad: %d", rec.Code)
+	}
+	if rec := serve(h, "POST", "/bad", "0123456789", nil); rec.Code != 413 {
+		t.Errorf("large body: %d", rec.Code)
+	}
+
+	h.MaxSteps = 10000
+	logged = nil
+	if rec := serve(h, "GET", "/spin", "", nil); rec.Code != 500 || len(logged) != 1 || !strings.Contains(logged[0], "too many steps") {
+		t.Errorf("steps: %d %q", rec.Code, logged)
+	}
+
+	h.MaxSteps = 0
+	h.Timeout = 20 * time.Millisecond
+	start := time.Now()
+	if rec := serve(h, "GET", "/spin", "", nil); rec.Code != 504 {
+		t.Errorf("timeout: %d", rec.Code)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Fatalf("timeout took %v", d)
+	}
+}